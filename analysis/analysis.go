@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"ipfs-connect2all/annotation"
 	"ipfs-connect2all/input"
 	"os"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -28,6 +31,10 @@ type FilesForAnalysis struct {
 	EstablishedConnectionsFile *CrawlOrSnapshotFile
 	SuccessfulConnectionsFile *CrawlOrSnapshotFile
 	FailedConnectionsFile *CrawlOrSnapshotFile
+	// optional, nil if there is no peer sources snapshot
+	PeerSourcesFile *CrawlOrSnapshotFile
+	// snapshot document (SnapshotFormat=json), the other snapshot files are nil if set
+	SnapshotDocumentFile *CrawlOrSnapshotFile
 }
 
 type MapsForAnalysis struct {
@@ -37,6 +44,10 @@ type MapsForAnalysis struct {
 	EstablishedConnections map[peer.ID]peer.ID
 	SuccessfulConnections map[peer.ID]peer.ID
 	FailedConnections map[peer.ID]peer.ID
+	// country and ASN of the known peers, only if recorded in the snapshot
+	Annotations map[peer.ID]annotation.Annotation
+	// peer source of the connection attempt by connect2all, only if recorded in the snapshot
+	PeerSources map[peer.ID]string
 }
 
 type ComparisonResult struct {
@@ -58,32 +69,60 @@ type ComparisonResult struct {
 }
 
 func GetCrawlAndSnapshotFiles(crawlPath string, snapshotPath string) (CrawlOrSnapshotFiles, error) {
-	dhtCrawlDir, err := os.Open(crawlPath)
+	dhtCrawlFiles, err := listFiles(crawlPath)
 	if err != nil {
-		return nil, fmt.Errorf("DHT crawl dir could not be opened: %s", err.Error())
+		return nil, fmt.Errorf("Contents of DHT crawl dir could not be fetched: %s", err.Error())
 	}
-	snapshotDir, err := os.Open(snapshotPath)
+	snapshotFiles, err := listFiles(snapshotPath)
 	if err != nil {
-		return nil, fmt.Errorf("Snapshot dir could not be opened: %s", err.Error())
+		return nil, fmt.Errorf("Contents of snapshot dir could not be fetched: %s", err.Error())
 	}
-	dhtCrawlCandidates, err := dhtCrawlDir.Readdirnames(-1)
+	return append(dhtCrawlFiles, snapshotFiles...), nil
+}
+
+// like GetCrawlAndSnapshotFiles, but for the files of a single directory (e.g., only crawls or wantlistLog files)
+func GetFiles(path string) (CrawlOrSnapshotFiles, error) {
+	ret, err := listFiles(path)
 	if err != nil {
-		return nil, fmt.Errorf("Contents of DHT crawl dir could not be fetched: %s", err.Error())
+		return nil, fmt.Errorf("Contents of dir %s could not be fetched: %s", path, err.Error())
 	}
-	snapshotCandidates, err := snapshotDir.Readdirnames(-1)
+	return ret, nil
+}
+
+// files in path and its subdirectories (e.g., per-day snapshot shards), not recursive
+func listFiles(path string) (CrawlOrSnapshotFiles, error) {
+	entries, err := readDir(path)
 	if err != nil {
-		return nil, fmt.Errorf("Contents of snapshot dir could not be fetched: %s", err.Error())
+		return nil, err
 	}
-	ret := make([]CrawlOrSnapshotFile, 0, len(dhtCrawlCandidates) + len(snapshotCandidates))
-	for _, dcc := range dhtCrawlCandidates {
-		ret = append(ret, CrawlOrSnapshotFile{Filename: dcc, Directory: crawlPath})
-	}
-	for _, sc := range snapshotCandidates {
-		ret = append(ret, CrawlOrSnapshotFile{Filename: sc, Directory: snapshotPath})
+	ret := make([]CrawlOrSnapshotFile, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			ret = append(ret, CrawlOrSnapshotFile{Filename: entry.Name(), Directory: path})
+			continue
+		}
+		subEntries, err := readDir(path + "/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		for _, subEntry := range subEntries {
+			if !subEntry.IsDir() {
+				ret = append(ret, CrawlOrSnapshotFile{Filename: subEntry.Name(), Directory: path + "/" + entry.Name()})
+			}
+		}
 	}
 	return ret, nil
 }
 
+func readDir(path string) ([]os.FileInfo, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	return dir.Readdir(-1)
+}
+
 func (candidates CrawlOrSnapshotFiles) GetTimestamps(startsWith string, dateFormat string) []time.Time {
 	ret := make([]time.Time, 0, len(candidates)/2)
 	for _, currentFile := range candidates {
@@ -92,6 +131,10 @@ func (candidates CrawlOrSnapshotFiles) GetTimestamps(startsWith string, dateForm
 		if len(currentFileName) <= datePos || currentFileName[0:datePos] != startsWith {
 			continue
 		}
+		// incomplete file (see helpers.SnapshotBundle)
+		if strings.HasSuffix(currentFileName, ".tmp") {
+			continue
+		}
 		currentTimestamp, err := time.Parse(dateFormat, currentFileName[datePos:datePos+len(dateFormat)])
 		if err != nil {
 			continue
@@ -110,6 +153,10 @@ func (candidates CrawlOrSnapshotFiles) GetClosest(startsWith string, timestamp t
 		if len(currentFileName) <= datePos || currentFileName[0:datePos] != startsWith {
 			continue
 		}
+		// incomplete file (see helpers.SnapshotBundle)
+		if strings.HasSuffix(currentFileName, ".tmp") {
+			continue
+		}
 		currentTimestamp, err := time.Parse(dateFormat, currentFileName[datePos:datePos+len(dateFormat)])
 		if err != nil {
 			//fmt.Printf("Could not parse date of file %s, ignoring (error: %s).", currentFileName, err.Error())
@@ -128,6 +175,64 @@ func (candidates CrawlOrSnapshotFiles) GetClosest(startsWith string, timestamp t
 	return ret
 }
 
+// files of the first complete snapshot bundle (see helpers.SnapshotBundle) at or after timestamp by prefix
+// (e.g., known); nil if there are no manifests or if a snapshot without manifest (written before bundles were
+// introduced) is closer to timestamp, so that the closest files are used
+func (candidates CrawlOrSnapshotFiles) GetClosestBundle(timestamp time.Time,
+	dateFormat string) (map[string]*CrawlOrSnapshotFile, error) {
+	manifestTimestamps := candidates.GetTimestamps("manifest_", dateFormat)
+	if len(manifestTimestamps) == 0 {
+		return nil, nil
+	}
+	sort.Slice(manifestTimestamps, func(i, j int) bool {
+		return manifestTimestamps[i].Before(manifestTimestamps[j])
+	})
+
+	// first known_ file at or after timestamp which does not belong to a bundle
+	hasManifest := make(map[int64]bool, len(manifestTimestamps))
+	for _, manifestTimestamp := range manifestTimestamps {
+		hasManifest[manifestTimestamp.UnixNano()] = true
+	}
+	var looseTimestamp time.Time
+	for _, knownTimestamp := range candidates.GetTimestamps("known_", dateFormat) {
+		if knownTimestamp.Before(timestamp) || hasManifest[knownTimestamp.UnixNano()] {
+			continue
+		}
+		if looseTimestamp.IsZero() || knownTimestamp.Before(looseTimestamp) {
+			looseTimestamp = knownTimestamp
+		}
+	}
+
+	for _, manifestTimestamp := range manifestTimestamps {
+		if manifestTimestamp.Before(timestamp) {
+			continue
+		}
+		if !looseTimestamp.IsZero() && looseTimestamp.Before(manifestTimestamp) {
+			return nil, nil
+		}
+		manifestFile := candidates.GetClosest("manifest_", manifestTimestamp, dateFormat)
+		if manifestFile == nil {
+			continue
+		}
+		manifest, err := input.LoadSnapshotManifest(manifestFile.GetPath())
+		if err != nil || !manifest.Complete {
+			continue
+		}
+		ret := make(map[string]*CrawlOrSnapshotFile, len(manifest.Files))
+		for _, manifestEntry := range manifest.Files {
+			ret[manifestEntry.Prefix] = &CrawlOrSnapshotFile{
+				Filename: manifestEntry.Filename,
+				Directory: manifestFile.Directory,
+			}
+		}
+		return ret, nil
+	}
+	if !looseTimestamp.IsZero() {
+		return nil, nil
+	}
+	return nil, errors.New("Error: No matching complete snapshot bundle found.")
+}
+
 func GetFilesForAnalysis(inputFiles CrawlOrSnapshotFiles, crawlTimestamp time.Time, snapshotTimestamp time.Time,
 							dateFormat string) (*FilesForAnalysis, error) {
 
@@ -139,31 +244,54 @@ func GetFilesForAnalysis(inputFiles CrawlOrSnapshotFiles, crawlTimestamp time.Ti
 		}
 	}
 
-	knownFile := inputFiles.GetClosest("known_", snapshotTimestamp, dateFormat)
+	// snapshot bundle with manifest if available, files with the closest timestamps otherwise (older snapshots)
+	getSnapshotFile := func(prefix string) *CrawlOrSnapshotFile {
+		return inputFiles.GetClosest(prefix+"_", snapshotTimestamp, dateFormat)
+	}
+	bundleFiles, err := inputFiles.GetClosestBundle(snapshotTimestamp, dateFormat)
+	if err != nil {
+		return nil, err
+	}
+	if bundleFiles != nil {
+		if documentFile, exists := bundleFiles["snapshot"]; exists {
+			return &FilesForAnalysis{
+				VisitedPeersFile: visitedPeersFile,
+				SnapshotDocumentFile: documentFile,
+			}, nil
+		}
+		getSnapshotFile = func(prefix string) *CrawlOrSnapshotFile {
+			return bundleFiles[prefix]
+		}
+	}
+
+	knownFile := getSnapshotFile("known")
 	if knownFile == nil {
 		return nil, errors.New("Error: No matching known peers snapshot file found.")
 	}
 
-	connectedFile := inputFiles.GetClosest("connected_", snapshotTimestamp, dateFormat)
+	connectedFile := getSnapshotFile("connected")
 	if connectedFile == nil {
 		return nil, errors.New("Error: No matching connected peers snapshot file found.")
 	}
 
-	establishedFile := inputFiles.GetClosest("established_", snapshotTimestamp, dateFormat)
+	establishedFile := getSnapshotFile("established")
 	if establishedFile == nil {
 		return nil, errors.New("Error: No matching established connections snapshot file found.")
 	}
 
-	successfulFile := inputFiles.GetClosest("successful_", snapshotTimestamp, dateFormat)
+	successfulFile := getSnapshotFile("successful")
 	if successfulFile == nil {
 		return nil, errors.New("Error: No matching successful connections snapshot file found.")
 	}
 
-	failedFile := inputFiles.GetClosest("failed_", snapshotTimestamp, dateFormat)
+	failedFile := getSnapshotFile("failed")
 	if failedFile == nil {
 		return nil, errors.New("Error: No matching failed connections snapshot file found.")
 	}
 
+	// not recorded in older snapshots
+	peerSourcesFile := getSnapshotFile("sources")
+
 	return &FilesForAnalysis{
 		VisitedPeersFile:           visitedPeersFile,
 		KnownPeersFile:             knownFile,
@@ -171,6 +299,7 @@ func GetFilesForAnalysis(inputFiles CrawlOrSnapshotFiles, crawlTimestamp time.Ti
 		EstablishedConnectionsFile: establishedFile,
 		SuccessfulConnectionsFile:  successfulFile,
 		FailedConnectionsFile:      failedFile,
+		PeerSourcesFile:            peerSourcesFile,
 	}, nil
 }
 
@@ -185,6 +314,9 @@ func GetMapsForAnalysis(filesForAnalysis FilesForAnalysis) (*MapsForAnalysis, er
 			return nil, fmt.Errorf("DHT peers could not be loaded: %s", err.Error())
 		}
 	}
+	if filesForAnalysis.SnapshotDocumentFile != nil {
+		return getMapsFromSnapshotDocument(visitedPeers, filesForAnalysis.SnapshotDocumentFile)
+	}
 	knownPeers, err := input.LoadPeerList(filesForAnalysis.KnownPeersFile.GetPath())
 	if err != nil {
 		return nil, fmt.Errorf("Known peers could not be loaded: %s", err.Error())
@@ -205,6 +337,17 @@ func GetMapsForAnalysis(filesForAnalysis FilesForAnalysis) (*MapsForAnalysis, er
 	if err != nil {
 		return nil, fmt.Errorf("Established connections could not be loaded: %s", err.Error())
 	}
+	annotations, err := input.LoadPeerAnnotations(filesForAnalysis.KnownPeersFile.GetPath())
+	if err != nil {
+		return nil, fmt.Errorf("Annotations could not be loaded: %s", err.Error())
+	}
+	peerSources := make(map[peer.ID]string)
+	if filesForAnalysis.PeerSourcesFile != nil {
+		peerSources, err = input.LoadPeerSources(filesForAnalysis.PeerSourcesFile.GetPath())
+		if err != nil {
+			return nil, fmt.Errorf("Peer sources could not be loaded: %s", err.Error())
+		}
+	}
 
 	return &MapsForAnalysis{
 		VisitedPeers: visitedPeers,
@@ -213,6 +356,8 @@ func GetMapsForAnalysis(filesForAnalysis FilesForAnalysis) (*MapsForAnalysis, er
 		EstablishedConnections: establishedConnections,
 		SuccessfulConnections: successfulConnections,
 		FailedConnections: failedConnections,
+		Annotations: annotations,
+		PeerSources: peerSources,
 	}, nil
 
 }
@@ -270,6 +415,32 @@ func CalculateComparisonResult(maps MapsForAnalysis) ComparisonResult {
 	return result
 }
 
+type SourceResult struct {
+	Attempts int
+	Successful int
+	Failed int
+}
+
+// attribute connection attempts, successes and failures to the peer sources
+func CalculateSourceResults(maps MapsForAnalysis) map[string]*SourceResult {
+	ret := make(map[string]*SourceResult)
+	for peerID, source := range maps.PeerSources {
+		result, ok := ret[source]
+		if !ok {
+			result = &SourceResult{}
+			ret[source] = result
+		}
+		result.Attempts++
+		if _, successful := maps.SuccessfulConnections[peerID]; successful {
+			result.Successful++
+		}
+		if _, failed := maps.FailedConnections[peerID]; failed {
+			result.Failed++
+		}
+	}
+	return ret
+}
+
 func CalculateDirections(connectedPeers map[peer.ID]*input.ConnectedPeer) (int, int) {
 	inbound := 0
 	outbound := 0
@@ -0,0 +1,163 @@
+package analysis
+
+import (
+	"fmt"
+	"github.com/ipfs/go-cid"
+	"sort"
+	"strconv"
+)
+
+// names of common multihash functions (go-cid only knows the codec names)
+var hashFunctionNames = map[uint64]string{
+	0x00:   "identity",
+	0x11:   "sha1",
+	0x12:   "sha2-256",
+	0x13:   "sha2-512",
+	0x14:   "sha3-512",
+	0x16:   "sha3-256",
+	0x1b:   "keccak-256",
+	0x1e:   "blake3",
+	0x56:   "dbl-sha2-256",
+	0xb220: "blake2b-256",
+	0xb260: "blake2s-256",
+}
+
+type CidStructure struct {
+	// false if the CID could not be decoded, all other fields are empty then
+	Valid        bool
+	Version      uint64
+	Codec        string
+	HashFunction string
+	DigestLength int
+}
+
+func DecodeCidStructure(cidString string) CidStructure {
+	c, err := cid.Decode(cidString)
+	if err != nil {
+		return CidStructure{}
+	}
+	prefix := c.Prefix()
+	// names from the multicodec table for the most common codecs, go-cid uses older ones
+	var codec string
+	switch prefix.Codec {
+	case cid.DagProtobuf:
+		codec = "dag-pb"
+	case cid.DagCBOR:
+		codec = "dag-cbor"
+	case cid.Raw:
+		codec = "raw"
+	default:
+		var known bool
+		codec, known = cid.CodecToStr[prefix.Codec]
+		if !known {
+			codec = fmt.Sprintf("0x%x", prefix.Codec)
+		}
+	}
+	hashFunction, known := hashFunctionNames[prefix.MhType]
+	if !known {
+		hashFunction = fmt.Sprintf("0x%x", prefix.MhType)
+	}
+	return CidStructure{
+		Valid:        true,
+		Version:      prefix.Version,
+		Codec:        codec,
+		HashFunction: hashFunction,
+		DigestLength: prefix.MhLength,
+	}
+}
+
+// distinct CIDs of a snapshot by structure, for the time series
+type CidStructureCounts struct {
+	V0         int
+	V1         int
+	DagPb      int
+	Raw        int
+	DagCbor    int
+	OtherCodec int
+	Sha256     int
+	OtherHash  int
+	Invalid    int
+}
+
+func (c *CidStructureCounts) Add(structure CidStructure) {
+	if !structure.Valid {
+		c.Invalid++
+		return
+	}
+	if structure.Version == 0 {
+		c.V0++
+	} else {
+		c.V1++
+	}
+	switch structure.Codec {
+	case "dag-pb":
+		c.DagPb++
+	case "raw":
+		c.Raw++
+	case "dag-cbor":
+		c.DagCbor++
+	default:
+		c.OtherCodec++
+	}
+	if structure.HashFunction == "sha2-256" {
+		c.Sha256++
+	} else {
+		c.OtherHash++
+	}
+}
+
+func (c CidStructureCounts) Ints() []int {
+	return []int{c.V0, c.V1, c.DagPb, c.Raw, c.DagCbor, c.OtherCodec, c.Sha256, c.OtherHash, c.Invalid}
+}
+
+// rows for the CID structure file: property (version, codec, hash, length), value, distinct CIDs, requests;
+// sorted by property and number of distinct CIDs
+func CidStructureRows(results []CidResult, structures map[string]CidStructure) [][]string {
+	type key struct {
+		property string
+		value    string
+	}
+	cids := make(map[key]int)
+	requests := make(map[key]int)
+	for _, result := range results {
+		structure, exists := structures[result.Cid]
+		if !exists {
+			structure = DecodeCidStructure(result.Cid)
+		}
+		var keys []key
+		if structure.Valid {
+			keys = []key{
+				{"version", strconv.FormatUint(structure.Version, 10)},
+				{"codec", structure.Codec},
+				{"hash", structure.HashFunction},
+				{"length", strconv.Itoa(structure.DigestLength)},
+			}
+		} else {
+			keys = []key{{"invalid", "-"}}
+		}
+		for _, k := range keys {
+			cids[k]++
+			requests[k] += result.Counts.Total()
+		}
+	}
+
+	propertyOrder := map[string]int{"version": 0, "codec": 1, "hash": 2, "length": 3, "invalid": 4}
+	keys := make([]key, 0, len(cids))
+	for k := range cids {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].property != keys[j].property {
+			return propertyOrder[keys[i].property] < propertyOrder[keys[j].property]
+		}
+		if cids[keys[i]] != cids[keys[j]] {
+			return cids[keys[i]] > cids[keys[j]]
+		}
+		return keys[i].value < keys[j].value
+	})
+	ret := make([][]string, 0, len(keys))
+	for _, k := range keys {
+		ret = append(ret, []string{k.property, k.value, strconv.Itoa(cids[k]), strconv.Itoa(requests[k])})
+	}
+	return ret
+}
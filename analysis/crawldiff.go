@@ -0,0 +1,120 @@
+package analysis
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"ipfs-connect2all/input"
+	"sort"
+	"strings"
+)
+
+// kinds of changes between two consecutive crawls, as used in the detail files
+const (
+	CrawlDiffAppeared           = "appeared"
+	CrawlDiffDisappeared        = "disappeared"
+	CrawlDiffBecameReachable    = "reachable"
+	CrawlDiffBecameUnreachable  = "unreachable"
+	CrawlDiffAgentVersionChange = "agent"
+	CrawlDiffAddressesChange    = "addrs"
+)
+
+type CrawlDiffEntry struct {
+	PeerID peer.ID
+	// one of the CrawlDiff* constants
+	Change string
+	// old and new value (reachability, agent version or addresses), empty for appeared/disappeared
+	Old string
+	New string
+}
+
+type CrawlDiffResult struct {
+	PreviousPeers     int
+	CurrentPeers      int
+	Appeared          int
+	Disappeared       int
+	BecameReachable   int
+	BecameUnreachable int
+	// only counted if the agent version is known in both crawls
+	AgentVersionChanged int
+	AddressesChanged    int
+	Entries             []CrawlDiffEntry
+}
+
+// compare the visited peers of two consecutive crawls
+func CalculateCrawlDiff(previous map[peer.ID]*input.VisitedPeer,
+	current map[peer.ID]*input.VisitedPeer) CrawlDiffResult {
+	var result CrawlDiffResult
+	result.PreviousPeers = len(previous)
+	result.CurrentPeers = len(current)
+	result.Entries = make([]CrawlDiffEntry, 0)
+	addEntry := func(peerID peer.ID, change string, oldValue string, newValue string) {
+		result.Entries = append(result.Entries, CrawlDiffEntry{PeerID: peerID, Change: change, Old: oldValue,
+			New: newValue})
+	}
+
+	for peerID, currentPeer := range current {
+		previousPeer, inPrevious := previous[peerID]
+		if !inPrevious {
+			result.Appeared++
+			addEntry(peerID, CrawlDiffAppeared, "", "")
+			continue
+		}
+		if !previousPeer.Reachable && currentPeer.Reachable {
+			result.BecameReachable++
+			addEntry(peerID, CrawlDiffBecameReachable, "0", "1")
+		} else if previousPeer.Reachable && !currentPeer.Reachable {
+			result.BecameUnreachable++
+			addEntry(peerID, CrawlDiffBecameUnreachable, "1", "0")
+		}
+		// the agent version is usually unknown if a peer was not reachable, which is no change of the agent
+		if previousPeer.AgentVersion != "" && currentPeer.AgentVersion != "" &&
+			previousPeer.AgentVersion != currentPeer.AgentVersion {
+			result.AgentVersionChanged++
+			addEntry(peerID, CrawlDiffAgentVersionChange, previousPeer.AgentVersion, currentPeer.AgentVersion)
+		}
+		previousAddrs := multiAddrsToString(previousPeer.MultiAddrs)
+		currentAddrs := multiAddrsToString(currentPeer.MultiAddrs)
+		if previousAddrs != currentAddrs {
+			result.AddressesChanged++
+			addEntry(peerID, CrawlDiffAddressesChange, previousAddrs, currentAddrs)
+		}
+	}
+	for peerID := range previous {
+		if _, inCurrent := current[peerID]; !inCurrent {
+			result.Disappeared++
+			addEntry(peerID, CrawlDiffDisappeared, "", "")
+		}
+	}
+
+	sort.Slice(result.Entries, func(i, j int) bool {
+		if result.Entries[i].Change != result.Entries[j].Change {
+			return result.Entries[i].Change < result.Entries[j].Change
+		}
+		return result.Entries[i].PeerID < result.Entries[j].PeerID
+	})
+	return result
+}
+
+// rows for a detail file: peer ID, change, old value, new value
+func (r CrawlDiffResult) Rows() [][]string {
+	ret := make([][]string, 0, len(r.Entries))
+	for _, entry := range r.Entries {
+		ret = append(ret, []string{entry.PeerID.String(), entry.Change, entry.Old, entry.New})
+	}
+	return ret
+}
+
+// sorted, comma-separated addresses, so that the order of the addresses in the crawl output does not matter
+func multiAddrsToString(multiAddrs []multiaddr.Multiaddr) string {
+	addrs := make([]string, 0, len(multiAddrs))
+	seen := make(map[string]bool, len(multiAddrs))
+	for _, ma := range multiAddrs {
+		addr := ma.String()
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ",")
+}
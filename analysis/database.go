@@ -0,0 +1,44 @@
+package analysis
+
+import (
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"ipfs-connect2all/database"
+	"ipfs-connect2all/helpers"
+	"ipfs-connect2all/input"
+	"time"
+)
+
+// snapshot taken at timestamp (see database.DB.SnapshotTimestamps) as document, only with the given snapshot
+// types (all if none are given)
+func GetDatabaseSnapshotDocument(db *database.DB, timestamp time.Time,
+	prefixes ...string) (*helpers.SnapshotDocument, error) {
+	rows, failed, err := db.Snapshot(timestamp, prefixes...)
+	if err != nil {
+		return nil, err
+	}
+	failures := make([]helpers.SnapshotManifestFailure, len(failed))
+	for i, failure := range failed {
+		failures[i] = helpers.SnapshotManifestFailure{Prefix: failure.Prefix, Error: failure.Error}
+	}
+	return helpers.NewSnapshotDocumentFromRows(timestamp, rows, failures)
+}
+
+// like GetMapsForAnalysis, for a crawl (nil: none) and the snapshot taken at snapshotTimestamp in the database (see
+// database.DB.NextCrawl and NextSnapshot)
+func GetMapsFromDatabase(db *database.DB, crawl *database.Crawl,
+	snapshotTimestamp time.Time) (*MapsForAnalysis, error) {
+	visitedPeers := make(map[peer.ID]*input.VisitedPeer)
+	if crawl != nil {
+		var err error
+		visitedPeers, err = input.DatabaseVisitedPeers(crawl)
+		if err != nil {
+			return nil, fmt.Errorf("DHT peers could not be loaded: %s", err.Error())
+		}
+	}
+	document, err := GetDatabaseSnapshotDocument(db, snapshotTimestamp)
+	if err != nil {
+		return nil, fmt.Errorf("Snapshot could not be loaded: %s", err.Error())
+	}
+	return mapsFromSnapshotDocument(visitedPeers, document)
+}
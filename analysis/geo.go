@@ -0,0 +1,200 @@
+package analysis
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"ipfs-connect2all/annotation"
+	"sort"
+	"strconv"
+)
+
+type GeoBreakdownEntry struct {
+	DhtPeers              int
+	ReachableDhtPeers     int
+	KnownPeers            int
+	ConnectedPeers        int
+	SuccessfulConnections int
+	FailedConnections     int
+}
+
+// numbers of peers per country and per ASN, summed up over all added comparisons
+type GeoBreakdown struct {
+	Countries   map[string]*GeoBreakdownEntry
+	ASNs        map[uint32]*GeoBreakdownEntry
+	Comparisons int
+}
+
+func NewGeoBreakdown() *GeoBreakdown {
+	return &GeoBreakdown{
+		Countries: make(map[string]*GeoBreakdownEntry),
+		ASNs:      make(map[uint32]*GeoBreakdownEntry),
+	}
+}
+
+// annotations of peers from the snapshot, completed by looking up the addresses of the connections (connected_
+// snapshots) and the addresses from the DHT crawl
+func GetAnnotations(maps MapsForAnalysis, annotator *annotation.Annotator) map[peer.ID]annotation.Annotation {
+	ret := make(map[peer.ID]annotation.Annotation, len(maps.Annotations)+len(maps.VisitedPeers))
+	for peerID, peerAnnotation := range maps.Annotations {
+		ret[peerID] = peerAnnotation
+	}
+	if annotator == nil {
+		return ret
+	}
+	lookup := func(peerID peer.ID, addrs []multiaddr.Multiaddr) {
+		if existing, ok := ret[peerID]; ok && (existing.Country != annotation.UnknownCountry || existing.ASN != 0) {
+			return
+		}
+		ret[peerID] = annotator.LookupAddrs(addrs)
+	}
+	// not in older snapshots
+	for peerID, connectedPeer := range maps.ConnectedPeers {
+		if connectedPeer.Addr != nil {
+			lookup(peerID, []multiaddr.Multiaddr{connectedPeer.Addr})
+		}
+	}
+	for peerID, visitedPeer := range maps.VisitedPeers {
+		lookup(peerID, visitedPeer.MultiAddrs)
+	}
+	return ret
+}
+
+// numbers of distinct countries of the known, connected, successful and failed peers, followed by the numbers of
+// distinct ASNs of these peers (peers without country resp. ASN are not counted); columns of the comparison files
+func CalculateGeoColumns(maps MapsForAnalysis, annotations map[peer.ID]annotation.Annotation) []int {
+	countries := func(peerIDs []peer.ID) int {
+		distinct := make(map[string]bool)
+		for _, peerID := range peerIDs {
+			if country := annotations[peerID].Country; country != "" && country != annotation.UnknownCountry {
+				distinct[country] = true
+			}
+		}
+		return len(distinct)
+	}
+	asns := func(peerIDs []peer.ID) int {
+		distinct := make(map[uint32]bool)
+		for _, peerID := range peerIDs {
+			if asn := annotations[peerID].ASN; asn != 0 {
+				distinct[asn] = true
+			}
+		}
+		return len(distinct)
+	}
+	connectedPeers := make([]peer.ID, 0, len(maps.ConnectedPeers))
+	for peerID := range maps.ConnectedPeers {
+		connectedPeers = append(connectedPeers, peerID)
+	}
+	peerLists := [][]peer.ID{peerIDList(maps.KnownPeers), connectedPeers, peerIDList(maps.SuccessfulConnections),
+		peerIDList(maps.FailedConnections)}
+	ret := make([]int, 0, 2*len(peerLists))
+	for _, peerIDs := range peerLists {
+		ret = append(ret, countries(peerIDs))
+	}
+	for _, peerIDs := range peerLists {
+		ret = append(ret, asns(peerIDs))
+	}
+	return ret
+}
+
+func peerIDList(peers map[peer.ID]peer.ID) []peer.ID {
+	ret := make([]peer.ID, 0, len(peers))
+	for peerID := range peers {
+		ret = append(ret, peerID)
+	}
+	return ret
+}
+
+// add the peers of one comparison to the breakdown
+func (b *GeoBreakdown) Add(maps MapsForAnalysis, annotations map[peer.ID]annotation.Annotation) {
+	b.Comparisons++
+	add := func(peerID peer.ID, count func(entry *GeoBreakdownEntry)) {
+		peerAnnotation, ok := annotations[peerID]
+		if !ok {
+			peerAnnotation = annotation.Annotation{Country: annotation.UnknownCountry}
+		}
+		countryEntry, ok := b.Countries[peerAnnotation.Country]
+		if !ok {
+			countryEntry = &GeoBreakdownEntry{}
+			b.Countries[peerAnnotation.Country] = countryEntry
+		}
+		asnEntry, ok := b.ASNs[peerAnnotation.ASN]
+		if !ok {
+			asnEntry = &GeoBreakdownEntry{}
+			b.ASNs[peerAnnotation.ASN] = asnEntry
+		}
+		count(countryEntry)
+		count(asnEntry)
+	}
+
+	for peerID, visitedPeer := range maps.VisitedPeers {
+		reachable := visitedPeer.Reachable
+		add(peerID, func(entry *GeoBreakdownEntry) {
+			entry.DhtPeers++
+			if reachable {
+				entry.ReachableDhtPeers++
+			}
+		})
+	}
+	for peerID := range maps.KnownPeers {
+		add(peerID, func(entry *GeoBreakdownEntry) { entry.KnownPeers++ })
+	}
+	for peerID := range maps.ConnectedPeers {
+		add(peerID, func(entry *GeoBreakdownEntry) { entry.ConnectedPeers++ })
+	}
+	for peerID := range maps.SuccessfulConnections {
+		add(peerID, func(entry *GeoBreakdownEntry) { entry.SuccessfulConnections++ })
+	}
+	for peerID := range maps.FailedConnections {
+		add(peerID, func(entry *GeoBreakdownEntry) { entry.FailedConnections++ })
+	}
+}
+
+// rows with the key and the mean numbers per comparison, sorted by the number of known peers
+func (b *GeoBreakdown) CountryRows() [][]string {
+	keys := make([]string, 0, len(b.Countries))
+	entries := make([]*GeoBreakdownEntry, 0, len(b.Countries))
+	for country, entry := range b.Countries {
+		keys = append(keys, country)
+		entries = append(entries, entry)
+	}
+	return b.breakdownRows(keys, entries)
+}
+
+func (b *GeoBreakdown) ASNRows() [][]string {
+	keys := make([]string, 0, len(b.ASNs))
+	entries := make([]*GeoBreakdownEntry, 0, len(b.ASNs))
+	for asn, entry := range b.ASNs {
+		keys = append(keys, strconv.FormatUint(uint64(asn), 10))
+		entries = append(entries, entry)
+	}
+	return b.breakdownRows(keys, entries)
+}
+
+func (b *GeoBreakdown) breakdownRows(keys []string, entries []*GeoBreakdownEntry) [][]string {
+	indices := make([]int, len(keys))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		if entries[indices[i]].KnownPeers != entries[indices[j]].KnownPeers {
+			return entries[indices[i]].KnownPeers > entries[indices[j]].KnownPeers
+		}
+		return keys[indices[i]] < keys[indices[j]]
+	})
+
+	comparisons := float64(b.Comparisons)
+	if comparisons < 1 {
+		comparisons = 1
+	}
+	mean := func(v int) string {
+		return strconv.FormatFloat(float64(v)/comparisons, 'f', 6, 64)
+	}
+	ret := make([][]string, 0, len(keys))
+	for _, i := range indices {
+		entry := entries[i]
+		ret = append(ret, []string{keys[i], mean(entry.DhtPeers), mean(entry.ReachableDhtPeers),
+			mean(entry.KnownPeers), mean(entry.ConnectedPeers), mean(entry.SuccessfulConnections),
+			mean(entry.FailedConnections)})
+	}
+	return ret
+}
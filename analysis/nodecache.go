@@ -0,0 +1,113 @@
+package analysis
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"ipfs-connect2all/input"
+	"sort"
+	"time"
+)
+
+// what the crawls and snapshots tell about a peer, for maintaining node caches
+type PeerSighting struct {
+	// last time the peer was reachable in a crawl or newly successful in a snapshot
+	LastSeen time.Time
+	// whether the peer is contained in any crawl, and whether it was reachable in the last one containing it
+	InCrawl            bool
+	LastCrawlReachable bool
+	// addresses from the last crawl containing the peer with addresses
+	Addrs []multiaddr.Multiaddr
+	// addresses from the last known_ snapshot containing the peer with addresses (only with SnapshotKnownAddrs)
+	SnapshotAddrs []multiaddr.Multiaddr
+}
+
+// collect sightings of peers from all visitedPeers files and (if useSnapshots) successful_ snapshots in the
+// order of their timestamps, and (if useSnapshots) the addresses of the peers from the known_ snapshots; also
+// returns the newest timestamp of all files
+func CollectPeerSightings(files CrawlOrSnapshotFiles, dateFormat string,
+	useSnapshots bool) (map[peer.ID]*PeerSighting, time.Time, error) {
+	sightings := make(map[peer.ID]*PeerSighting)
+	var newest time.Time
+	getSighting := func(peerID peer.ID) *PeerSighting {
+		sighting, exists := sightings[peerID]
+		if !exists {
+			sighting = &PeerSighting{}
+			sightings[peerID] = sighting
+		}
+		return sighting
+	}
+
+	crawlTimestamps := files.GetTimestamps("visitedPeers_", dateFormat)
+	sort.Slice(crawlTimestamps, func(i, j int) bool {
+		return crawlTimestamps[i].Before(crawlTimestamps[j])
+	})
+	for _, ts := range crawlTimestamps {
+		visitedPeersFile := files.GetClosest("visitedPeers_", ts, dateFormat)
+		if visitedPeersFile == nil {
+			continue
+		}
+		visitedPeers, err := input.LoadVisitedPeers(visitedPeersFile.GetPath())
+		if err != nil {
+			return nil, newest, err
+		}
+		for peerID, visitedPeer := range visitedPeers {
+			sighting := getSighting(peerID)
+			sighting.InCrawl = true
+			sighting.LastCrawlReachable = visitedPeer.Reachable
+			if visitedPeer.Reachable && ts.After(sighting.LastSeen) {
+				sighting.LastSeen = ts
+			}
+			if len(visitedPeer.MultiAddrs) > 0 {
+				sighting.Addrs = visitedPeer.MultiAddrs
+			}
+		}
+		if ts.After(newest) {
+			newest = ts
+		}
+	}
+
+	if !useSnapshots {
+		return sightings, newest, nil
+	}
+
+	// successful_ snapshots are cumulative within a run of connect2all, so a peer is only seen at the time of the
+	// first snapshot after its successful connection (resp. after a restart of connect2all)
+	snapshotTimestamps := files.GetSnapshotTimestamps("successful", dateFormat)
+	previousSuccessful := make(map[peer.ID]peer.ID)
+	for _, ts := range snapshotTimestamps {
+		successful, err := files.LoadSnapshotSuccessfulConnections(ts, dateFormat)
+		if err != nil {
+			return nil, newest, err
+		}
+		for peerID := range successful {
+			if _, alreadySuccessful := previousSuccessful[peerID]; alreadySuccessful {
+				continue
+			}
+			sighting := getSighting(peerID)
+			if ts.After(sighting.LastSeen) {
+				sighting.LastSeen = ts
+			}
+		}
+		previousSuccessful = successful
+		if ts.After(newest) {
+			newest = ts
+		}
+	}
+
+	// known_ snapshots only contain addresses with SnapshotKnownAddrs, peers are not seen by being known
+	for _, ts := range files.GetSnapshotTimestamps("known", dateFormat) {
+		knownAddrs, err := files.LoadSnapshotKnownPeerAddrs(ts, dateFormat)
+		if err != nil {
+			return nil, newest, err
+		}
+		for peerID, summary := range knownAddrs {
+			if len(summary.Addrs) == 0 {
+				continue
+			}
+			if sighting, exists := sightings[peerID]; exists {
+				sighting.SnapshotAddrs = summary.Addrs
+			}
+		}
+	}
+	return sightings, newest, nil
+}
@@ -0,0 +1,118 @@
+package analysis
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	"ipfs-connect2all/input"
+)
+
+type PeerGraphResult struct {
+	Nodes        int
+	CrawledNodes int
+	Edges        int
+	// peers which only appear as neighbours, but have not been crawled themselves
+	NeighbourOnlyNodes int
+	// weakly connected components
+	Components                int
+	LargestComponent          int
+	MeanInDegree              float64
+	MeanInDegreeSuccessful    float64
+	MeanInDegreeFailed        float64
+	MeanInDegreeNeighbourOnly float64
+	// number of peers per degree
+	InDegrees     map[int]int
+	OutDegrees    map[int]int
+	NeighbourOnly map[peer.ID]bool
+}
+
+// analyze peer graph of a crawl, successful and failed connections of connect2all from the maps (may be empty)
+func CalculatePeerGraphResult(graph *input.PeerGraph, maps MapsForAnalysis) PeerGraphResult {
+	var result PeerGraphResult
+	inDegree := make(map[peer.ID]int)
+	nodes := make(map[peer.ID]bool)
+	for source, neighbours := range graph.Neighbours {
+		nodes[source] = true
+		if _, ok := inDegree[source]; !ok {
+			inDegree[source] = 0
+		}
+		for _, target := range neighbours {
+			nodes[target] = true
+			inDegree[target]++
+		}
+		result.Edges += len(neighbours)
+	}
+	result.Nodes = len(nodes)
+	result.CrawledNodes = len(graph.Neighbours)
+
+	result.InDegrees = make(map[int]int)
+	result.OutDegrees = make(map[int]int)
+	result.NeighbourOnly = make(map[peer.ID]bool)
+	inDegreeSum, inDegreeSumSuccessful, inDegreeSumFailed, inDegreeSumNeighbourOnly := 0, 0, 0, 0
+	numSuccessful, numFailed := 0, 0
+	for node := range nodes {
+		degree := inDegree[node]
+		result.InDegrees[degree]++
+		result.OutDegrees[len(graph.Neighbours[node])]++
+		inDegreeSum += degree
+		if _, crawled := graph.Neighbours[node]; !crawled {
+			result.NeighbourOnly[node] = true
+			inDegreeSumNeighbourOnly += degree
+		}
+		if _, successful := maps.SuccessfulConnections[node]; successful {
+			inDegreeSumSuccessful += degree
+			numSuccessful++
+		}
+		if _, failed := maps.FailedConnections[node]; failed {
+			inDegreeSumFailed += degree
+			numFailed++
+		}
+	}
+	result.NeighbourOnlyNodes = len(result.NeighbourOnly)
+
+	mean := func(sum int, count int) float64 {
+		if count == 0 {
+			return 0
+		}
+		return float64(sum) / float64(count)
+	}
+	result.MeanInDegree = mean(inDegreeSum, result.Nodes)
+	result.MeanInDegreeSuccessful = mean(inDegreeSumSuccessful, numSuccessful)
+	result.MeanInDegreeFailed = mean(inDegreeSumFailed, numFailed)
+	result.MeanInDegreeNeighbourOnly = mean(inDegreeSumNeighbourOnly, result.NeighbourOnlyNodes)
+
+	result.Components, result.LargestComponent = weaklyConnectedComponents(graph, nodes)
+	return result
+}
+
+// number of weakly connected components and size of the largest one (union-find)
+func weaklyConnectedComponents(graph *input.PeerGraph, nodes map[peer.ID]bool) (int, int) {
+	parent := make(map[peer.ID]peer.ID, len(nodes))
+	for node := range nodes {
+		parent[node] = node
+	}
+	find := func(node peer.ID) peer.ID {
+		for parent[node] != node {
+			parent[node] = parent[parent[node]]
+			node = parent[node]
+		}
+		return node
+	}
+	for source, neighbours := range graph.Neighbours {
+		for _, target := range neighbours {
+			rootSource, rootTarget := find(source), find(target)
+			if rootSource != rootTarget {
+				parent[rootSource] = rootTarget
+			}
+		}
+	}
+
+	sizes := make(map[peer.ID]int)
+	largest := 0
+	for node := range nodes {
+		root := find(node)
+		sizes[root]++
+		if sizes[root] > largest {
+			largest = sizes[root]
+		}
+	}
+	return len(sizes), largest
+}
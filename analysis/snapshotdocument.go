@@ -0,0 +1,142 @@
+package analysis
+
+import (
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"ipfs-connect2all/helpers"
+	"ipfs-connect2all/input"
+	"sort"
+	"time"
+)
+
+func getMapsFromSnapshotDocument(visitedPeers map[peer.ID]*input.VisitedPeer,
+	documentFile *CrawlOrSnapshotFile) (*MapsForAnalysis, error) {
+	document, err := input.LoadSnapshotDocument(documentFile.GetPath())
+	if err != nil {
+		return nil, fmt.Errorf("Snapshot document could not be loaded: %s", err.Error())
+	}
+	return mapsFromSnapshotDocument(visitedPeers, document)
+}
+
+func mapsFromSnapshotDocument(visitedPeers map[peer.ID]*input.VisitedPeer,
+	document *helpers.SnapshotDocument) (*MapsForAnalysis, error) {
+	knownPeers, err := input.DocumentKnownPeers(document)
+	if err != nil {
+		return nil, fmt.Errorf("Known peers could not be loaded: %s", err.Error())
+	}
+	connectedPeers, err := input.DocumentConnectedPeers(document)
+	if err != nil {
+		return nil, fmt.Errorf("Connected peers could not be loaded: %s", err.Error())
+	}
+	successfulConnections, err := input.DocumentPeerList(document.Successful)
+	if err != nil {
+		return nil, fmt.Errorf("Successful connections could not be loaded: %s", err.Error())
+	}
+	failedConnections, err := input.DocumentPeerList(document.Failed)
+	if err != nil {
+		return nil, fmt.Errorf("Failed connections could not be loaded: %s", err.Error())
+	}
+	establishedConnections, err := input.DocumentPeerList(document.Established)
+	if err != nil {
+		return nil, fmt.Errorf("Established connections could not be loaded: %s", err.Error())
+	}
+	annotations, err := input.DocumentPeerAnnotations(document)
+	if err != nil {
+		return nil, fmt.Errorf("Annotations could not be loaded: %s", err.Error())
+	}
+	peerSources, err := input.DocumentPeerSources(document)
+	if err != nil {
+		return nil, fmt.Errorf("Peer sources could not be loaded: %s", err.Error())
+	}
+
+	return &MapsForAnalysis{
+		VisitedPeers:           visitedPeers,
+		KnownPeers:             knownPeers,
+		ConnectedPeers:         connectedPeers,
+		EstablishedConnections: establishedConnections,
+		SuccessfulConnections:  successfulConnections,
+		FailedConnections:      failedConnections,
+		Annotations:            annotations,
+		PeerSources:            peerSources,
+	}, nil
+}
+
+// sorted timestamps of the snapshots containing the given snapshot type (e.g., connected), from <prefix>_ files
+// and snapshot documents
+func (candidates CrawlOrSnapshotFiles) GetSnapshotTimestamps(prefix string, dateFormat string) []time.Time {
+	ret := append(candidates.GetTimestamps(prefix+"_", dateFormat),
+		candidates.GetTimestamps("snapshot_", dateFormat)...)
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Before(ret[j])
+	})
+	return ret
+}
+
+// snapshot document with exactly the given timestamp, nil if there is none
+func (candidates CrawlOrSnapshotFiles) getSnapshotDocument(timestamp time.Time,
+	dateFormat string) (*helpers.SnapshotDocument, error) {
+	documentFile := candidates.GetClosest("snapshot_", timestamp, dateFormat)
+	if documentFile == nil {
+		return nil, nil
+	}
+	datePos := len("snapshot_")
+	documentTimestamp, err := time.Parse(dateFormat, documentFile.Filename[datePos:datePos+len(dateFormat)])
+	if err != nil || !documentTimestamp.Equal(timestamp) {
+		return nil, nil
+	}
+	return input.LoadSnapshotDocument(documentFile.GetPath())
+}
+
+// connected peers of the snapshot taken at timestamp (see GetSnapshotTimestamps), from a connected_ file or a
+// snapshot document
+func (candidates CrawlOrSnapshotFiles) LoadSnapshotConnectedPeers(timestamp time.Time,
+	dateFormat string) (map[peer.ID]*input.ConnectedPeer, error) {
+	document, err := candidates.getSnapshotDocument(timestamp, dateFormat)
+	if err != nil {
+		return nil, err
+	}
+	if document != nil {
+		return input.DocumentConnectedPeers(document)
+	}
+	connectedFile := candidates.GetClosest("connected_", timestamp, dateFormat)
+	if connectedFile == nil {
+		return nil, fmt.Errorf("No connected peers snapshot found for %s", timestamp)
+	}
+	return input.LoadConnectedPeers(connectedFile.GetPath())
+}
+
+// successful connections of the snapshot taken at timestamp (see GetSnapshotTimestamps), from a successful_ file
+// or a snapshot document
+func (candidates CrawlOrSnapshotFiles) LoadSnapshotSuccessfulConnections(timestamp time.Time,
+	dateFormat string) (map[peer.ID]peer.ID, error) {
+	document, err := candidates.getSnapshotDocument(timestamp, dateFormat)
+	if err != nil {
+		return nil, err
+	}
+	if document != nil {
+		return input.DocumentPeerList(document.Successful)
+	}
+	successfulFile := candidates.GetClosest("successful_", timestamp, dateFormat)
+	if successfulFile == nil {
+		return nil, fmt.Errorf("No successful connections snapshot found for %s", timestamp)
+	}
+	return input.LoadPeerList(successfulFile.GetPath())
+}
+
+// addresses of the known peers of the snapshot taken at timestamp (see GetSnapshotTimestamps), from a known_ file
+// or a snapshot document; empty if the snapshot has no addresses (SnapshotKnownAddrs not set)
+func (candidates CrawlOrSnapshotFiles) LoadSnapshotKnownPeerAddrs(timestamp time.Time,
+	dateFormat string) (map[peer.ID]helpers.AddrSummary, error) {
+	document, err := candidates.getSnapshotDocument(timestamp, dateFormat)
+	if err != nil {
+		return nil, err
+	}
+	if document != nil {
+		return input.DocumentKnownPeerAddrs(document)
+	}
+	knownFile := candidates.GetClosest("known_", timestamp, dateFormat)
+	if knownFile == nil {
+		return nil, fmt.Errorf("No known peers snapshot found for %s", timestamp)
+	}
+	return input.LoadKnownPeerAddrs(knownFile.GetPath())
+}
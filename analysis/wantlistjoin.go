@@ -0,0 +1,293 @@
+package analysis
+
+import (
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"ipfs-connect2all/input"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	ActivityUnknown      = "unknown"
+	ActivityNotConnected = "not connected"
+)
+
+// upper bounds of the connection age groups, older connections are in the last group
+var connectionAgeBins = []struct {
+	maxAge time.Duration
+	label  string
+}{
+	{10 * time.Minute, "<10m"},
+	{time.Hour, "10m-1h"},
+	{6 * time.Hour, "1h-6h"},
+	{24 * time.Hour, "6h-1d"},
+}
+
+// order of the connection age groups in the output
+var ConnectionAgeGroups = []string{"<10m", "10m-1h", "1h-6h", "6h-1d", ">=1d", ActivityNotConnected}
+
+func connectionAgeGroup(age time.Duration) string {
+	for _, bin := range connectionAgeBins {
+		if age < bin.maxAge {
+			return bin.label
+		}
+	}
+	return ">=1d"
+}
+
+func directionGroup(direction network.Direction) string {
+	switch direction {
+	case network.DirInbound:
+		return "inbound"
+	case network.DirOutbound:
+		return "outbound"
+	}
+	return ActivityUnknown
+}
+
+type PeerConnection struct {
+	Direction network.Direction
+	// age of the connection from the snapshot if recorded, time since the first snapshot of the uninterrupted
+	// series of snapshots in which the peer was connected otherwise (lower bound, resolution of the snapshot
+	// interval)
+	Age time.Duration
+	// from the snapshot, empty if not recorded
+	AgentVersion string
+}
+
+type ConnectionSnapshot struct {
+	Timestamp time.Time
+	Peers     map[peer.ID]PeerConnection
+}
+
+// connected peers snapshots of a run, see AddSnapshot
+type ConnectionHistory struct {
+	Snapshots      []ConnectionSnapshot
+	connectedSince map[peer.ID]time.Time
+	// any snapshot contains agent versions
+	hasAgentVersions bool
+}
+
+func NewConnectionHistory() *ConnectionHistory {
+	return &ConnectionHistory{
+		Snapshots:      make([]ConnectionSnapshot, 0),
+		connectedSince: make(map[peer.ID]time.Time),
+	}
+}
+
+// add connected peers snapshot, snapshots must be added in chronological order
+func (h *ConnectionHistory) AddSnapshot(timestamp time.Time, connectedPeers map[peer.ID]*input.ConnectedPeer) {
+	snapshot := ConnectionSnapshot{Timestamp: timestamp, Peers: make(map[peer.ID]PeerConnection, len(connectedPeers))}
+	connectedSince := make(map[peer.ID]time.Time, len(connectedPeers))
+	for peerID, connectedPeer := range connectedPeers {
+		since, exists := h.connectedSince[peerID]
+		if !exists {
+			since = timestamp
+		}
+		connectedSince[peerID] = since
+		connection := PeerConnection{
+			Direction:    connectedPeer.Direction,
+			Age:          timestamp.Sub(since),
+			AgentVersion: connectedPeer.AgentVersion,
+		}
+		if connectedPeer.Age >= 0 {
+			connection.Age = connectedPeer.Age
+		}
+		if connection.AgentVersion != "" {
+			h.hasAgentVersions = true
+		}
+		snapshot.Peers[peerID] = connection
+	}
+	// peers missing in this snapshot start a new connection when they reappear
+	h.connectedSince = connectedSince
+	h.Snapshots = append(h.Snapshots, snapshot)
+}
+
+// whether any snapshot contains agent versions (connected_ files with agent versions or snapshot documents)
+func (h *ConnectionHistory) HasAgentVersions() bool {
+	return h.hasAgentVersions
+}
+
+// connection of the peer in the first snapshot at or after timestamp (last snapshot if there is none), false if
+// the peer was not connected or there are no snapshots
+func (h *ConnectionHistory) Get(peerID peer.ID, timestamp time.Time) (PeerConnection, bool) {
+	if len(h.Snapshots) == 0 {
+		return PeerConnection{}, false
+	}
+	i := sort.Search(len(h.Snapshots), func(i int) bool {
+		return !h.Snapshots[i].Timestamp.Before(timestamp)
+	})
+	if i == len(h.Snapshots) {
+		i--
+	}
+	connection, connected := h.Snapshots[i].Peers[peerID]
+	return connection, connected
+}
+
+type agentVersionSighting struct {
+	timestamp    time.Time
+	agentVersion string
+}
+
+// agent versions of the peers over several crawls, see AddCrawl
+type AgentVersionHistory struct {
+	sightings map[peer.ID][]agentVersionSighting
+}
+
+func NewAgentVersionHistory() *AgentVersionHistory {
+	return &AgentVersionHistory{sightings: make(map[peer.ID][]agentVersionSighting)}
+}
+
+// add the agent versions of a crawl, crawls must be added in chronological order
+func (h *AgentVersionHistory) AddCrawl(timestamp time.Time, visitedPeers map[peer.ID]*input.VisitedPeer) {
+	for peerID, visitedPeer := range visitedPeers {
+		if visitedPeer.AgentVersion == "" {
+			continue
+		}
+		h.sightings[peerID] = append(h.sightings[peerID], agentVersionSighting{timestamp, visitedPeer.AgentVersion})
+	}
+}
+
+// agent version of the peer in the last crawl before or at timestamp in which it was known (first later crawl if
+// there is none), empty if unknown
+func (h *AgentVersionHistory) Get(peerID peer.ID, timestamp time.Time) string {
+	sightings := h.sightings[peerID]
+	if len(sightings) == 0 {
+		return ""
+	}
+	i := sort.Search(len(sightings), func(i int) bool {
+		return sightings[i].timestamp.After(timestamp)
+	})
+	if i > 0 {
+		i--
+	}
+	return sightings[i].agentVersion
+}
+
+type WantlistActivity struct {
+	Group string
+	// distinct peers, a peer can be in several groups (e.g., with increasing connection age)
+	Peers int
+	// snapshots in which a peer of the group requested anything, summed up over all peers
+	PeerSnapshots int
+	Counts        WantCounts
+	peers         map[string]struct{}
+}
+
+// requests per peer and snapshot
+func (a *WantlistActivity) RequestsPerPeerSnapshot() float64 {
+	if a.PeerSnapshots == 0 {
+		return 0
+	}
+	return float64(a.Counts.Total()) / float64(a.PeerSnapshots)
+}
+
+// request activity of the wantlist senders by agent version, connection direction and connection age; connection
+// data is only available if connections is set, agent versions only if agentVersions is set or the connected
+// peers snapshots contain them
+type WantlistActivityAnalysis struct {
+	connections     *ConnectionHistory
+	agentVersions   *AgentVersionHistory
+	ByAgentVersion  map[string]*WantlistActivity
+	ByDirection     map[string]*WantlistActivity
+	ByConnectionAge map[string]*WantlistActivity
+}
+
+func NewWantlistActivityAnalysis(connections *ConnectionHistory,
+	agentVersions *AgentVersionHistory) *WantlistActivityAnalysis {
+	return &WantlistActivityAnalysis{
+		connections:     connections,
+		agentVersions:   agentVersions,
+		ByAgentVersion:  make(map[string]*WantlistActivity),
+		ByDirection:     make(map[string]*WantlistActivity),
+		ByConnectionAge: make(map[string]*WantlistActivity),
+	}
+}
+
+func addActivity(groups map[string]*WantlistActivity, group string, peerID string, counts WantCounts) {
+	activity, exists := groups[group]
+	if !exists {
+		activity = &WantlistActivity{Group: group, peers: make(map[string]struct{})}
+		groups[group] = activity
+	}
+	if _, seen := activity.peers[peerID]; !seen {
+		activity.peers[peerID] = struct{}{}
+		activity.Peers++
+	}
+	activity.PeerSnapshots++
+	activity.Counts.WantHave += counts.WantHave
+	activity.Counts.WantBlock += counts.WantBlock
+}
+
+// whether agent versions are available from the crawls or the connected peers snapshots
+func (a *WantlistActivityAnalysis) HasAgentVersions() bool {
+	return a.agentVersions != nil || (a.connections != nil && a.connections.HasAgentVersions())
+}
+
+// add the requests of a peer in the wantlist snapshot taken at timestamp (see WantlistAnalysis.AddPeer)
+func (a *WantlistActivityAnalysis) AddPeer(timestamp time.Time, peerIDString string, counts WantCounts) {
+	peerID, err := peer.Decode(peerIDString)
+	valid := err == nil
+
+	var connection PeerConnection
+	connected := false
+	if a.connections != nil && valid {
+		connection, connected = a.connections.Get(peerID, timestamp)
+	}
+
+	// agent version of the connection if recorded, of the crawls otherwise
+	if a.HasAgentVersions() {
+		agentVersion := connection.AgentVersion
+		if agentVersion == "" && a.agentVersions != nil && valid {
+			agentVersion = a.agentVersions.Get(peerID, timestamp)
+		}
+		if agentVersion == "" {
+			agentVersion = ActivityUnknown
+		}
+		addActivity(a.ByAgentVersion, agentVersion, peerIDString, counts)
+	}
+
+	if a.connections != nil {
+		if connected {
+			addActivity(a.ByDirection, directionGroup(connection.Direction), peerIDString, counts)
+			addActivity(a.ByConnectionAge, connectionAgeGroup(connection.Age), peerIDString, counts)
+		} else {
+			addActivity(a.ByDirection, ActivityNotConnected, peerIDString, counts)
+			addActivity(a.ByConnectionAge, ActivityNotConnected, peerIDString, counts)
+		}
+	}
+}
+
+// rows for the activity files: group, peers, peer snapshots, requests, WANT_HAVE, WANT_BLOCK, WANT_HAVE share,
+// requests per peer and snapshot; groups in the given order (empty groups are skipped), sorted by number of
+// requests (descending) if order is nil
+func WantlistActivityRows(groups map[string]*WantlistActivity, order []string) [][]string {
+	activities := make([]*WantlistActivity, 0, len(groups))
+	if order != nil {
+		for _, group := range order {
+			if activity, exists := groups[group]; exists {
+				activities = append(activities, activity)
+			}
+		}
+	} else {
+		for _, activity := range groups {
+			activities = append(activities, activity)
+		}
+		sort.Slice(activities, func(i, j int) bool {
+			if activities[i].Counts.Total() != activities[j].Counts.Total() {
+				return activities[i].Counts.Total() > activities[j].Counts.Total()
+			}
+			return activities[i].Group < activities[j].Group
+		})
+	}
+	ret := make([][]string, 0, len(activities))
+	for _, activity := range activities {
+		ret = append(ret, []string{activity.Group, strconv.Itoa(activity.Peers), strconv.Itoa(activity.PeerSnapshots),
+			strconv.Itoa(activity.Counts.Total()), strconv.Itoa(activity.Counts.WantHave),
+			strconv.Itoa(activity.Counts.WantBlock), formatFloat(activity.Counts.WantHaveShare()),
+			formatFloat(activity.RequestsPerPeerSnapshot())})
+	}
+	return ret
+}
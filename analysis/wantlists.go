@@ -0,0 +1,297 @@
+package analysis
+
+import (
+	"ipfs-connect2all/helpers"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type WantCounts struct {
+	WantHave  int
+	WantBlock int
+}
+
+func (c WantCounts) Total() int {
+	return c.WantHave + c.WantBlock
+}
+
+// share of WANT_HAVE requests in all requests, 0 if there are no requests
+func (c WantCounts) WantHaveShare() float64 {
+	if c.Total() == 0 {
+		return 0
+	}
+	return float64(c.WantHave) / float64(c.Total())
+}
+
+type WantlistSnapshotResult struct {
+	Timestamp time.Time
+	Peers     int
+	Cids      int
+	Counts    WantCounts
+	// number of requesting peers per CID
+	CidPeers  map[string]int
+	Structure CidStructureCounts
+}
+
+// accumulates the wantlistLog files of a run, see AddPeer and FinishSnapshot
+type WantlistAnalysis struct {
+	// wantlist cache not reset after each snapshot (DoNotResetWantlistCache), i.e., counts are cumulative
+	cumulative bool
+	// requests per peer and CID over all snapshots
+	requests map[string]map[string]WantCounts
+	// last counts per peer and CID as recorded, only if cumulative
+	lastCounts map[string]map[string]WantCounts
+	// number of snapshots in which a peer requested anything
+	peerSnapshots map[string]int
+	// decoded CIDs
+	structures map[string]CidStructure
+	Snapshots  []WantlistSnapshotResult
+	current    *WantlistSnapshotResult
+}
+
+func NewWantlistAnalysis(cumulative bool) *WantlistAnalysis {
+	return &WantlistAnalysis{
+		cumulative:    cumulative,
+		requests:      make(map[string]map[string]WantCounts),
+		lastCounts:    make(map[string]map[string]WantCounts),
+		peerSnapshots: make(map[string]int),
+		structures:    make(map[string]CidStructure),
+		Snapshots:     make([]WantlistSnapshotResult, 0),
+	}
+}
+
+// add the wantlist of a peer in the snapshot taken at timestamp; the peers of a snapshot must be added before
+// FinishSnapshot is called; returns the requests of the peer in this snapshot; if cumulative, the recorded counts
+// are converted to the increase since the last snapshot first (the counts themselves if they decreased, e.g.,
+// after a restart of connect2all), so that the snapshot results and the totals are the same as without
+// DoNotResetWantlistCache
+func (a *WantlistAnalysis) AddPeer(timestamp time.Time, logPeer helpers.WantlistLogPeer) WantCounts {
+	if a.current == nil {
+		a.current = &WantlistSnapshotResult{Timestamp: timestamp, CidPeers: make(map[string]int)}
+	}
+	var ret WantCounts
+	if len(logPeer.Entries) == 0 {
+		return ret
+	}
+	var peerLastCounts map[string]WantCounts
+	if a.cumulative {
+		peerLastCounts = a.lastCounts[logPeer.PeerID]
+		if peerLastCounts == nil {
+			peerLastCounts = make(map[string]WantCounts)
+			a.lastCounts[logPeer.PeerID] = peerLastCounts
+		}
+	}
+	peerRequests, exists := a.requests[logPeer.PeerID]
+	if !exists {
+		peerRequests = make(map[string]WantCounts)
+	}
+	for _, entry := range logPeer.Entries {
+		counts := WantCounts{WantHave: entry.NumWantHave, WantBlock: entry.NumWantBlock}
+		if a.cumulative {
+			previous := peerLastCounts[entry.Cid]
+			peerLastCounts[entry.Cid] = counts
+			if counts.WantHave >= previous.WantHave && counts.WantBlock >= previous.WantBlock {
+				counts.WantHave -= previous.WantHave
+				counts.WantBlock -= previous.WantBlock
+			}
+			// no requests since the last snapshot
+			if counts.Total() == 0 {
+				continue
+			}
+		}
+		ret.WantHave += counts.WantHave
+		ret.WantBlock += counts.WantBlock
+		sum := peerRequests[entry.Cid]
+		sum.WantHave += counts.WantHave
+		sum.WantBlock += counts.WantBlock
+		peerRequests[entry.Cid] = sum
+		a.current.CidPeers[entry.Cid]++
+		a.current.Counts.WantHave += counts.WantHave
+		a.current.Counts.WantBlock += counts.WantBlock
+	}
+	if ret.Total() == 0 {
+		return ret
+	}
+	a.requests[logPeer.PeerID] = peerRequests
+	a.current.Peers++
+	a.peerSnapshots[logPeer.PeerID]++
+	return ret
+}
+
+func (a *WantlistAnalysis) FinishSnapshot(timestamp time.Time) {
+	if a.current == nil {
+		a.current = &WantlistSnapshotResult{Timestamp: timestamp, CidPeers: make(map[string]int)}
+	}
+	a.current.Cids = len(a.current.CidPeers)
+	for contentID := range a.current.CidPeers {
+		structure, exists := a.structures[contentID]
+		if !exists {
+			structure = DecodeCidStructure(contentID)
+			a.structures[contentID] = structure
+		}
+		a.current.Structure.Add(structure)
+	}
+	a.Snapshots = append(a.Snapshots, *a.current)
+	a.current = nil
+}
+
+// structure of all requested CIDs, see CidStructureRows
+func (a *WantlistAnalysis) CidStructureRows(results []CidResult) [][]string {
+	return CidStructureRows(results, a.structures)
+}
+
+type CidResult struct {
+	Cid    string
+	Counts WantCounts
+	Peers  int
+}
+
+// all requested CIDs, sorted by number of requests (descending)
+func (a *WantlistAnalysis) CidResults() []CidResult {
+	byCid := make(map[string]*CidResult)
+	for _, peerRequests := range a.requests {
+		for contentID, counts := range peerRequests {
+			result, exists := byCid[contentID]
+			if !exists {
+				result = &CidResult{Cid: contentID}
+				byCid[contentID] = result
+			}
+			result.Counts.WantHave += counts.WantHave
+			result.Counts.WantBlock += counts.WantBlock
+			result.Peers++
+		}
+	}
+	ret := make([]CidResult, 0, len(byCid))
+	for _, result := range byCid {
+		ret = append(ret, *result)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Counts.Total() != ret[j].Counts.Total() {
+			return ret[i].Counts.Total() > ret[j].Counts.Total()
+		}
+		return ret[i].Cid < ret[j].Cid
+	})
+	return ret
+}
+
+type WantlistPeerResult struct {
+	PeerID string
+	Counts WantCounts
+	Cids   int
+	// number of snapshots in which the peer requested anything
+	Snapshots int
+}
+
+// all requesting peers, sorted by number of requests (descending)
+func (a *WantlistAnalysis) PeerResults() []WantlistPeerResult {
+	ret := make([]WantlistPeerResult, 0, len(a.requests))
+	for peerID, peerRequests := range a.requests {
+		result := WantlistPeerResult{PeerID: peerID, Cids: len(peerRequests), Snapshots: a.peerSnapshots[peerID]}
+		for _, counts := range peerRequests {
+			result.Counts.WantHave += counts.WantHave
+			result.Counts.WantBlock += counts.WantBlock
+		}
+		ret = append(ret, result)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Counts.Total() != ret[j].Counts.Total() {
+			return ret[i].Counts.Total() > ret[j].Counts.Total()
+		}
+		return ret[i].PeerID < ret[j].PeerID
+	})
+	return ret
+}
+
+type WantlistOverlap struct {
+	PeerA      string
+	PeerB      string
+	SharedCids int
+	// shared CIDs divided by CIDs requested by any of both peers
+	Jaccard float64
+}
+
+// overlap of the requested CIDs between all pairs of the given peers with at least one shared CID,
+// sorted by number of shared CIDs (descending)
+func (a *WantlistAnalysis) Overlaps(peerIDs []string) []WantlistOverlap {
+	ret := make([]WantlistOverlap, 0)
+	for i := 0; i < len(peerIDs); i++ {
+		requestsA := a.requests[peerIDs[i]]
+		for j := i + 1; j < len(peerIDs); j++ {
+			requestsB := a.requests[peerIDs[j]]
+			shared := 0
+			for contentID := range requestsA {
+				if _, ok := requestsB[contentID]; ok {
+					shared++
+				}
+			}
+			if shared == 0 {
+				continue
+			}
+			ret = append(ret, WantlistOverlap{
+				PeerA:      peerIDs[i],
+				PeerB:      peerIDs[j],
+				SharedCids: shared,
+				Jaccard:    float64(shared) / float64(len(requestsA)+len(requestsB)-shared),
+			})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].SharedCids != ret[j].SharedCids {
+			return ret[i].SharedCids > ret[j].SharedCids
+		}
+		if ret[i].PeerA != ret[j].PeerA {
+			return ret[i].PeerA < ret[j].PeerA
+		}
+		return ret[i].PeerB < ret[j].PeerB
+	})
+	return ret
+}
+
+// requests per minute, assuming that each snapshot covers the given interval
+func requestsPerMinute(requests int, snapshots int, interval time.Duration) float64 {
+	minutes := float64(snapshots) * interval.Minutes()
+	if minutes == 0 {
+		return 0
+	}
+	return float64(requests) / minutes
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', 6, 64)
+}
+
+// rows for the CID file: CID, requests, WANT_HAVE, WANT_BLOCK, WANT_HAVE share, requesting peers
+func CidRows(results []CidResult) [][]string {
+	ret := make([][]string, 0, len(results))
+	for _, result := range results {
+		ret = append(ret, []string{result.Cid, strconv.Itoa(result.Counts.Total()),
+			strconv.Itoa(result.Counts.WantHave), strconv.Itoa(result.Counts.WantBlock),
+			formatFloat(result.Counts.WantHaveShare()), strconv.Itoa(result.Peers)})
+	}
+	return ret
+}
+
+// rows for the peer file: peer ID, requests, WANT_HAVE, WANT_BLOCK, WANT_HAVE share, requested CIDs,
+// requests per minute over all snapshots and over the snapshots in which the peer requested anything
+func (a *WantlistAnalysis) PeerRows(results []WantlistPeerResult, interval time.Duration) [][]string {
+	ret := make([][]string, 0, len(results))
+	for _, result := range results {
+		ret = append(ret, []string{result.PeerID, strconv.Itoa(result.Counts.Total()),
+			strconv.Itoa(result.Counts.WantHave), strconv.Itoa(result.Counts.WantBlock),
+			formatFloat(result.Counts.WantHaveShare()), strconv.Itoa(result.Cids),
+			formatFloat(requestsPerMinute(result.Counts.Total(), len(a.Snapshots), interval)),
+			formatFloat(requestsPerMinute(result.Counts.Total(), result.Snapshots, interval))})
+	}
+	return ret
+}
+
+// rows for the overlap file: peer IDs, shared CIDs, Jaccard index
+func OverlapRows(overlaps []WantlistOverlap) [][]string {
+	ret := make([][]string, 0, len(overlaps))
+	for _, overlap := range overlaps {
+		ret = append(ret, []string{overlap.PeerA, overlap.PeerB, strconv.Itoa(overlap.SharedCids),
+			formatFloat(overlap.Jaccard)})
+	}
+	return ret
+}
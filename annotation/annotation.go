@@ -0,0 +1,252 @@
+package annotation
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/oschwald/maxminddb-golang"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const UnknownCountry = "--"
+
+type Annotation struct {
+	Country string
+	ASN     uint32
+	ASOrg   string
+}
+
+// IP address database (MaxMind DB or CSV ranges), may contain countries, ASNs or both
+type Database interface {
+	Lookup(ip net.IP) (*Annotation, error)
+}
+
+// combines several databases, e.g., a country and an ASN database
+type Annotator struct {
+	databases []Database
+}
+
+// open database files (*.mmdb files are read as MaxMind DB, all other files as CSV ranges)
+func NewAnnotator(filenames []string) (*Annotator, error) {
+	ret := &Annotator{databases: make([]Database, 0, len(filenames))}
+	for _, filename := range filenames {
+		filename = strings.TrimSpace(filename)
+		if filename == "" {
+			continue
+		}
+		var db Database
+		var err error
+		if strings.HasSuffix(strings.ToLower(filename), ".mmdb") {
+			db, err = OpenMmdbDatabase(filename)
+		} else {
+			db, err = OpenCsvDatabase(filename)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret.databases = append(ret.databases, db)
+	}
+	return ret, nil
+}
+
+// annotate an IP address with the merged information of all databases
+func (a *Annotator) LookupIP(ip net.IP) Annotation {
+	ret := Annotation{Country: UnknownCountry}
+	for _, db := range a.databases {
+		result, err := db.Lookup(ip)
+		if err != nil || result == nil {
+			continue
+		}
+		if ret.Country == UnknownCountry && result.Country != "" {
+			ret.Country = result.Country
+		}
+		if ret.ASN == 0 && result.ASN != 0 {
+			ret.ASN = result.ASN
+			ret.ASOrg = result.ASOrg
+		}
+	}
+	return ret
+}
+
+// annotate a peer by the first of its addresses which is a public IP address
+func (a *Annotator) LookupAddrs(addrs []multiaddr.Multiaddr) Annotation {
+	for _, addr := range addrs {
+		ip, err := manet.ToIP(addr)
+		if err != nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+			continue
+		}
+		ret := a.LookupIP(ip)
+		if ret.Country != UnknownCountry || ret.ASN != 0 {
+			return ret
+		}
+	}
+	return Annotation{Country: UnknownCountry}
+}
+
+// snapshot columns: country code and ASN
+func (a Annotation) CsvColumns() []string {
+	return []string{a.Country, strconv.FormatUint(uint64(a.ASN), 10)}
+}
+
+// parse snapshot columns written by CsvColumns
+func FromCsvColumns(columns []string) (Annotation, error) {
+	if len(columns) < 2 {
+		return Annotation{}, errors.New("Annotation needs two columns (country and ASN)")
+	}
+	asn, err := strconv.ParseUint(columns[1], 10, 32)
+	if err != nil {
+		return Annotation{}, errors.New("Could not parse ASN: " + err.Error())
+	}
+	return Annotation{Country: columns[0], ASN: uint32(asn)}, nil
+}
+
+type MmdbDatabase struct {
+	reader *maxminddb.Reader
+}
+
+func OpenMmdbDatabase(filename string) (*MmdbDatabase, error) {
+	buffer, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.New("Could not read MaxMind DB file: " + err.Error())
+	}
+	reader, err := maxminddb.FromBytes(buffer)
+	if err != nil {
+		return nil, errors.New("Invalid MaxMind DB file: " + err.Error())
+	}
+	return &MmdbDatabase{reader: reader}, nil
+}
+
+// fields used by the GeoLite2/GeoIP2 Country, City and ASN databases
+type mmdbRecord struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	ASN   uint32 `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// reads country.iso_code (or registered_country.iso_code), autonomous_system_number and
+// autonomous_system_organization, returns nil if the database contains no record for the address
+func (m *MmdbDatabase) Lookup(ip net.IP) (*Annotation, error) {
+	var record mmdbRecord
+	_, found, err := m.reader.LookupNetwork(ip, &record)
+	if err != nil || !found {
+		return nil, err
+	}
+	ret := &Annotation{Country: record.Country.IsoCode, ASN: record.ASN, ASOrg: record.ASOrg}
+	if ret.Country == "" {
+		ret.Country = record.RegisteredCountry.IsoCode
+	}
+	return ret, nil
+}
+
+type csvRange struct {
+	start      net.IP
+	end        net.IP
+	annotation Annotation
+}
+
+// sorted, non-overlapping IP ranges
+type CsvDatabase struct {
+	ranges []csvRange
+}
+
+// load CSV (comma- or tab-separated) range database with the columns range start, range end,
+// country code, ASN and (optionally) AS organization; # starts a comment line
+func OpenCsvDatabase(filename string) (*CsvDatabase, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.New("Could not open IP range database for reading: " + err.Error())
+	}
+	defer f.Close()
+
+	ret := &CsvDatabase{ranges: make([]csvRange, 0)}
+	scn := bufio.NewScanner(f)
+	for scn.Scan() {
+		line := strings.TrimSpace(scn.Text())
+		if len(line) < 1 || line[0] == '#' {
+			continue
+		}
+		separator := ","
+		if strings.IndexByte(line, '\t') >= 0 {
+			separator = "\t"
+		}
+		columns := strings.SplitN(line, separator, 5)
+		if len(columns) < 4 {
+			return nil, errors.New("Invalid row in IP range database (should have at least 4 columns): " + line)
+		}
+		start := normalizeIP(net.ParseIP(strings.TrimSpace(columns[0])))
+		end := normalizeIP(net.ParseIP(strings.TrimSpace(columns[1])))
+		if start == nil || end == nil || len(start) != len(end) {
+			return nil, errors.New("Invalid IP range in IP range database: " + line)
+		}
+		asnStr := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(columns[3])), "AS")
+		var asn uint64
+		if asnStr != "" {
+			asn, err = strconv.ParseUint(asnStr, 10, 32)
+			if err != nil {
+				return nil, errors.New("Invalid ASN in IP range database: " + line)
+			}
+		}
+		entry := csvRange{start: start, end: end,
+			annotation: Annotation{Country: strings.TrimSpace(columns[2]), ASN: uint32(asn)}}
+		if len(columns) > 4 {
+			entry.annotation.ASOrg = strings.Trim(strings.TrimSpace(columns[4]), "\"")
+		}
+		ret.ranges = append(ret.ranges, entry)
+	}
+	if err := scn.Err(); err != nil {
+		return nil, errors.New("Could not read IP range database: " + err.Error())
+	}
+
+	sort.Slice(ret.ranges, func(i, j int) bool {
+		return compareIPs(ret.ranges[i].start, ret.ranges[j].start) < 0
+	})
+	return ret, nil
+}
+
+func (c *CsvDatabase) Lookup(ip net.IP) (*Annotation, error) {
+	ip = normalizeIP(ip)
+	if ip == nil {
+		return nil, nil
+	}
+	// first range starting after ip, the candidate is the one before
+	i := sort.Search(len(c.ranges), func(i int) bool {
+		return compareIPs(c.ranges[i].start, ip) > 0
+	})
+	if i == 0 {
+		return nil, nil
+	}
+	candidate := c.ranges[i-1]
+	if compareIPs(candidate.end, ip) < 0 {
+		return nil, nil
+	}
+	ret := candidate.annotation
+	return &ret, nil
+}
+
+// IPv4 addresses in 4-byte form, IPv6 addresses in 16-byte form
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+// IPv4 addresses are sorted before IPv6 addresses
+func compareIPs(a net.IP, b net.IP) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return bytes.Compare(a, b)
+}
@@ -0,0 +1,121 @@
+package annotation
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// encoding of MaxMind DB data section values, see https://maxmind.github.io/MaxMind-DB/
+func mmdbString(s string) []byte {
+	if len(s) >= 29 {
+		return append([]byte{2<<5 | 29, byte(len(s) - 29)}, s...)
+	}
+	return append([]byte{byte(2<<5 | len(s))}, s...)
+}
+
+func mmdbMap(entries ...[]byte) []byte {
+	ret := []byte{byte(7<<5 | len(entries)/2)}
+	for _, entry := range entries {
+		ret = append(ret, entry...)
+	}
+	return ret
+}
+
+func mmdbUint16(v uint16) []byte {
+	return []byte{5<<5 | 2, byte(v >> 8), byte(v)}
+}
+
+func mmdbUint32(v uint32) []byte {
+	return []byte{6<<5 | 4, byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+// IPv4 database with a single node: 0.0.0.0/1 -> DE/AS3320, 128.0.0.0/1 -> no record
+func testMmdb() []byte {
+	ret := []byte{0, 0, 17, 0, 0, 1}
+	ret = append(ret, make([]byte, 16)...)
+	ret = append(ret, mmdbMap(
+		mmdbString("country"), mmdbMap(mmdbString("iso_code"), mmdbString("DE")),
+		mmdbString("autonomous_system_number"), mmdbUint32(3320),
+		mmdbString("autonomous_system_organization"), mmdbString("Deutsche Telekom AG"),
+	)...)
+	ret = append(ret, "\xab\xcd\xefMaxMind.com"...)
+	ret = append(ret, mmdbMap(
+		mmdbString("node_count"), mmdbUint32(1),
+		mmdbString("record_size"), mmdbUint16(24),
+		mmdbString("ip_version"), mmdbUint16(4),
+		mmdbString("binary_format_major_version"), mmdbUint16(2),
+		mmdbString("database_type"), mmdbString("Test"),
+	)...)
+	return ret
+}
+
+func writeTestFile(t *testing.T, dir string, name string, content []byte) string {
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestMmdbDatabaseLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "annotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenMmdbDatabase(writeTestFile(t, dir, "test.mmdb", testMmdb()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := db.Lookup(net.ParseIP("80.1.2.3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result == nil || result.Country != "DE" || result.ASN != 3320 || result.ASOrg != "Deutsche Telekom AG" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	result, err = db.Lookup(net.ParseIP("200.1.2.3"))
+	if err != nil || result != nil {
+		t.Fatalf("expected no record, got %+v (%v)", result, err)
+	}
+}
+
+func TestMmdbDatabaseInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "annotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := testMmdb()
+	corruptTree := append([]byte{}, valid...)
+	// record pointing far beyond the data section
+	corruptTree[0], corruptTree[1], corruptTree[2] = 0xff, 0xff, 0xff
+	corruptMetadata := append([]byte{}, valid...)
+	// node_count larger than the file
+	nodeCount := bytes.LastIndex(corruptMetadata, []byte("node_count")) + len("node_count")
+	corruptMetadata[nodeCount+1] = 0xff
+
+	files := map[string][]byte{
+		"empty.mmdb":     {},
+		"truncated.mmdb": valid[:len(valid)-20],
+		"nodata.mmdb":    valid[len(valid)/2:],
+		"tree.mmdb":      corruptTree,
+		"metadata.mmdb":  corruptMetadata,
+	}
+	for name, content := range files {
+		db, err := OpenMmdbDatabase(writeTestFile(t, dir, name, content))
+		if err != nil {
+			continue
+		}
+		// corrupt records must be reported as errors, not panic
+		if _, err := db.Lookup(net.ParseIP("80.1.2.3")); err == nil {
+			t.Errorf("%s: no error for corrupt database", name)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"ipfs-connect2all/analysis"
+	"ipfs-connect2all/database"
 	"ipfs-connect2all/helpers"
 	"os"
 	"time"
@@ -17,6 +19,7 @@ func main() {
 	configValues["DHTCrawlDir"] = "crawls"
 	configValues["SnapshotDir"] = "snapshots"
 	configValues["SnapshotTS"] = ""
+	configValues["Database"] = ""
 
 	// load config from command line and display help upon encountering bad options (including -h/--help/Help/help/...)
 	if !helpers.LoadConfig(&configValues, os.Args[1:]) || configValues["Timestamp"] == "" {
@@ -32,16 +35,12 @@ func main() {
 			"DHTCrawlDir=<dir>         Directory in which the snapshots are located\n" +
 			"SnapshotDir=<dir>         Directory in which the crawl output files are located\n" +
 			"SnapshotTS=<value>        Timestamp to use for snapshots (if given, the one\n" +
-			"                          from above will be used for the crawl only)")
+			"                          from above will be used for the crawl only)\n" +
+			"Database=<file>           Use crawl and snapshot from database file <file>\n" +
+			"                          instead of the directories")
 		return
 	}
 
-	// load list of candidate files
-	crawlAndSnapshotFiles, err := analysis.GetCrawlAndSnapshotFiles(configValues["DHTCrawlDir"], configValues["SnapshotDir"])
-	if err != nil {
-		panic(err.Error())
-	}
-
 	// choose the closest ones
 	dateFormat := configValues["DateFormat"]
 	inputTimestamp, err := time.Parse(dateFormat, configValues["Timestamp"])
@@ -58,21 +57,13 @@ func main() {
 		inputSnapshotTS = inputTimestamp
 	}
 
-	filesForAnalysis, err := analysis.GetFilesForAnalysis(crawlAndSnapshotFiles, inputTimestamp, inputSnapshotTS,
-		dateFormat)
-	if err != nil {
-		panic(err.Error())
+	var mapsForAnalysis *analysis.MapsForAnalysis
+	if configValues["Database"] != "" {
+		mapsForAnalysis, err = loadFromDatabase(configValues["Database"], inputTimestamp, inputSnapshotTS)
+	} else {
+		mapsForAnalysis, err = loadFromFiles(configValues["DHTCrawlDir"], configValues["SnapshotDir"], inputTimestamp,
+			inputSnapshotTS, dateFormat)
 	}
-
-	fmt.Printf("Using DHT crawl file: %s\n", filesForAnalysis.VisitedPeersFile)
-	fmt.Printf("Using known peers snapshot file: %s\n", filesForAnalysis.KnownPeersFile)
-	fmt.Printf("Using connected peers snapshot file: %s\n", filesForAnalysis.ConnectedPeersFile)
-	fmt.Printf("Using established connections snapshot file: %s\n", filesForAnalysis.EstablishedConnectionsFile)
-	fmt.Printf("Using successful connections snapshot file: %s\n", filesForAnalysis.SuccessfulConnectionsFile)
-	fmt.Printf("Using failed connections snapshot file: %s\n", filesForAnalysis.FailedConnectionsFile)
-	fmt.Println()
-
-	mapsForAnalysis, err := analysis.GetMapsForAnalysis(*filesForAnalysis)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -99,4 +90,62 @@ func main() {
 
 	// TODO unique IDs in certain intervals, stability of connections?
 
-}
\ No newline at end of file
+}
+
+func loadFromFiles(crawlDir string, snapshotDir string, inputTimestamp time.Time, inputSnapshotTS time.Time,
+	dateFormat string) (*analysis.MapsForAnalysis, error) {
+	// load list of candidate files
+	crawlAndSnapshotFiles, err := analysis.GetCrawlAndSnapshotFiles(crawlDir, snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+
+	filesForAnalysis, err := analysis.GetFilesForAnalysis(crawlAndSnapshotFiles, inputTimestamp, inputSnapshotTS,
+		dateFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Using DHT crawl file: %s\n", filesForAnalysis.VisitedPeersFile)
+	if filesForAnalysis.SnapshotDocumentFile != nil {
+		fmt.Printf("Using snapshot document: %s\n", filesForAnalysis.SnapshotDocumentFile)
+	} else {
+		fmt.Printf("Using known peers snapshot file: %s\n", filesForAnalysis.KnownPeersFile)
+		fmt.Printf("Using connected peers snapshot file: %s\n", filesForAnalysis.ConnectedPeersFile)
+		fmt.Printf("Using established connections snapshot file: %s\n", filesForAnalysis.EstablishedConnectionsFile)
+		fmt.Printf("Using successful connections snapshot file: %s\n", filesForAnalysis.SuccessfulConnectionsFile)
+		fmt.Printf("Using failed connections snapshot file: %s\n", filesForAnalysis.FailedConnectionsFile)
+	}
+	fmt.Println()
+
+	return analysis.GetMapsForAnalysis(*filesForAnalysis)
+}
+
+func loadFromDatabase(databaseFile string, inputTimestamp time.Time,
+	inputSnapshotTS time.Time) (*analysis.MapsForAnalysis, error) {
+	db, err := database.OpenReadOnly(databaseFile)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	crawl, err := db.NextCrawl(inputTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	if crawl == nil {
+		return nil, errors.New("Error: No matching DHT crawl found in database.")
+	}
+	snapshotTS, err := db.NextSnapshot(inputSnapshotTS)
+	if err != nil {
+		return nil, err
+	}
+	if snapshotTS.IsZero() {
+		return nil, errors.New("Error: No matching snapshot found in database.")
+	}
+
+	fmt.Printf("Using DHT crawl from database: %s\n", crawl.StartTime)
+	fmt.Printf("Using snapshot from database: %s\n\n", snapshotTS)
+
+	return analysis.GetMapsFromDatabase(db, crawl, snapshotTS)
+}
@@ -4,16 +4,20 @@ import (
 	"fmt"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"ipfs-connect2all/analysis"
+	"ipfs-connect2all/annotation"
 	"ipfs-connect2all/helpers"
+	"ipfs-connect2all/input"
 	"ipfs-connect2all/stats"
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-func calculateComparisons(wg *sync.WaitGroup, files analysis.CrawlOrSnapshotFiles, dateFormat string, outDir string) {
+func calculateComparisons(wg *sync.WaitGroup, files analysis.CrawlOrSnapshotFiles, dateFormat string, outDir string,
+							annotator *annotation.Annotator) {
 	defer wg.Done()
 	timestamps := files.GetTimestamps("visitedPeers_", dateFormat)
 
@@ -22,13 +26,24 @@ func calculateComparisons(wg *sync.WaitGroup, files analysis.CrawlOrSnapshotFile
 		return timestamps[i].Before(timestamps[j])
 	})
 
-	addWholeResult := func(sf *stats.StatsFile, comparisonResult analysis.ComparisonResult) {
-		sf.AddInts(comparisonResult.DhtPeers, comparisonResult.ReachableDhtPeers, comparisonResult.KnownPeers,
-			comparisonResult.ConnectedPeers, comparisonResult.SuccessfulConnections, comparisonResult.FailedConnections,
-			comparisonResult.DhtButNotKnown, comparisonResult.DhtButNotConnected,
+	// with the numbers of countries and ASNs if annotations are given
+	addWholeResult := func(sf *stats.StatsFile, comparisonResult analysis.ComparisonResult, geoColumns []int) {
+		sf.AddInts(append([]int{comparisonResult.DhtPeers, comparisonResult.ReachableDhtPeers,
+			comparisonResult.KnownPeers, comparisonResult.ConnectedPeers, comparisonResult.SuccessfulConnections,
+			comparisonResult.FailedConnections, comparisonResult.DhtButNotKnown, comparisonResult.DhtButNotConnected,
 			comparisonResult.DhtButNotSuccessful, comparisonResult.DhtButFailed, comparisonResult.KnownButNotDht,
 			comparisonResult.ConnectedButNotDht, comparisonResult.ConnectedButNotDhtReachable,
-			comparisonResult.SuccessfulButNotDht, comparisonResult.SuccessfulButNotDhtReachable)
+			comparisonResult.SuccessfulButNotDht, comparisonResult.SuccessfulButNotDhtReachable}, geoColumns...)...)
+	}
+	compare := func(sf *stats.StatsFile, maps *analysis.MapsForAnalysis) map[peer.ID]annotation.Annotation {
+		var annotations map[peer.ID]annotation.Annotation
+		var geoColumns []int
+		if annotator != nil {
+			annotations = analysis.GetAnnotations(*maps, annotator)
+			geoColumns = analysis.CalculateGeoColumns(*maps, annotations)
+		}
+		addWholeResult(sf, analysis.CalculateComparisonResult(*maps), geoColumns)
+		return annotations
 	}
 
 	// at the start of crawl
@@ -56,6 +71,12 @@ func calculateComparisons(wg *sync.WaitGroup, files analysis.CrawlOrSnapshotFile
 	}
 	defer sf30.FlushAndClose()
 
+	// per-country and per-ASN breakdown at the start of crawl
+	var geoBreakdown *analysis.GeoBreakdown
+	if annotator != nil {
+		geoBreakdown = analysis.NewGeoBreakdown()
+	}
+
 	for _, ts := range timestamps {
 		var wg sync.WaitGroup
 		wg.Add(4)
@@ -70,8 +91,10 @@ func calculateComparisons(wg *sync.WaitGroup, files analysis.CrawlOrSnapshotFile
 			if err != nil {
 				panic(err.Error())
 			}
-			comparisonResult := analysis.CalculateComparisonResult(*mapsForAnalysis)
-			addWholeResult(sf0, comparisonResult)
+			annotations := compare(sf0, mapsForAnalysis)
+			if geoBreakdown != nil {
+				geoBreakdown.Add(*mapsForAnalysis, annotations)
+			}
 		}()
 
 		go func() {
@@ -85,8 +108,7 @@ func calculateComparisons(wg *sync.WaitGroup, files analysis.CrawlOrSnapshotFile
 			if err != nil {
 				panic(err.Error())
 			}
-			comparisonResult := analysis.CalculateComparisonResult(*mapsForAnalysis)
-			addWholeResult(sf10, comparisonResult)
+			compare(sf10, mapsForAnalysis)
 		}()
 
 		go func() {
@@ -100,8 +122,7 @@ func calculateComparisons(wg *sync.WaitGroup, files analysis.CrawlOrSnapshotFile
 			if err != nil {
 				panic(err.Error())
 			}
-			comparisonResult := analysis.CalculateComparisonResult(*mapsForAnalysis)
-			addWholeResult(sf20, comparisonResult)
+			compare(sf20, mapsForAnalysis)
 		}()
 
 		go func() {
@@ -115,12 +136,117 @@ func calculateComparisons(wg *sync.WaitGroup, files analysis.CrawlOrSnapshotFile
 			if err != nil {
 				panic(err.Error())
 			}
-			comparisonResult := analysis.CalculateComparisonResult(*mapsForAnalysis)
-			addWholeResult(sf30, comparisonResult)
+			compare(sf30, mapsForAnalysis)
 		}()
 
 		wg.Wait()
 	}
+
+	if geoBreakdown != nil {
+		err = helpers.WriteTsvFile(outDir+"/countries.dat", geoBreakdown.CountryRows())
+		if err != nil {
+			panic(err.Error())
+		}
+		err = helpers.WriteTsvFile(outDir+"/asns.dat", geoBreakdown.ASNRows())
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+}
+
+func calculatePeerGraphs(wg *sync.WaitGroup, files analysis.CrawlOrSnapshotFiles, dateFormat string, outDir string) {
+	defer wg.Done()
+	timestamps := files.GetTimestamps("peerGraph_", dateFormat)
+
+	// sort timestamps
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i].Before(timestamps[j])
+	})
+
+	sfPeerGraph, err := stats.NewFile(outDir + "/peergraph.dat")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer sfPeerGraph.FlushAndClose()
+
+	inDegrees := make(map[int]int)
+	outDegrees := make(map[int]int)
+	neighbourOnlyCrawls := make(map[peer.ID]int)
+	numGraphs := 0
+
+	for _, ts := range timestamps {
+		peerGraphFile := files.GetClosest("peerGraph_", ts, dateFormat)
+		if peerGraphFile == nil {
+			continue
+		}
+		peerGraph, err := input.LoadPeerGraph(peerGraphFile.GetPath())
+		if err != nil {
+			panic(err.Error())
+		}
+
+		// successful and failed connections of connect2all at the start of the crawl, if available
+		mapsForAnalysis := &analysis.MapsForAnalysis{}
+		filesForAnalysis, err := analysis.GetFilesForAnalysis(files, time.Time{}, ts, dateFormat)
+		if err == nil {
+			mapsForAnalysis, err = analysis.GetMapsForAnalysis(*filesForAnalysis)
+			if err != nil {
+				panic(err.Error())
+			}
+		}
+
+		result := analysis.CalculatePeerGraphResult(peerGraph, *mapsForAnalysis)
+		sfPeerGraph.AddFloats(float64(result.Nodes), float64(result.CrawledNodes), float64(result.Edges),
+			float64(result.NeighbourOnlyNodes), float64(result.Components), float64(result.LargestComponent),
+			result.MeanInDegree, result.MeanInDegreeSuccessful, result.MeanInDegreeFailed,
+			result.MeanInDegreeNeighbourOnly)
+
+		for degree, count := range result.InDegrees {
+			inDegrees[degree] += count
+		}
+		for degree, count := range result.OutDegrees {
+			outDegrees[degree] += count
+		}
+		for peerID := range result.NeighbourOnly {
+			neighbourOnlyCrawls[peerID]++
+		}
+		numGraphs++
+	}
+
+	if numGraphs == 0 {
+		return
+	}
+
+	// degree distributions, mean number of peers per crawl
+	writeDegrees := func(filename string, degrees map[int]int) {
+		sf, err := stats.NewFile(filename)
+		if err != nil {
+			panic(err.Error())
+		}
+		defer sf.FlushAndClose()
+		sortedDegrees := make([]int, 0, len(degrees))
+		for degree := range degrees {
+			sortedDegrees = append(sortedDegrees, degree)
+		}
+		sort.Ints(sortedDegrees)
+		for _, degree := range sortedDegrees {
+			sf.AddFloats(float64(degree), float64(degrees[degree])/float64(numGraphs))
+		}
+	}
+	writeDegrees(outDir+"/indegrees.dat", inDegrees)
+	writeDegrees(outDir+"/outdegrees.dat", outDegrees)
+
+	// peers which were never crawled, but appeared as neighbours
+	neighbourOnlyRows := make([][]string, 0, len(neighbourOnlyCrawls))
+	for peerID, crawls := range neighbourOnlyCrawls {
+		neighbourOnlyRows = append(neighbourOnlyRows, []string{peerID.String(), strconv.Itoa(crawls)})
+	}
+	sort.Slice(neighbourOnlyRows, func(i, j int) bool {
+		return neighbourOnlyRows[i][0] < neighbourOnlyRows[j][0]
+	})
+	err = helpers.WriteTsvFile(outDir+"/neighbourOnly.dat", neighbourOnlyRows)
+	if err != nil {
+		panic(err.Error())
+	}
 }
 
 func calculateChurn(wg *sync.WaitGroup, files analysis.CrawlOrSnapshotFiles, dateFormat string, outDir string,
@@ -138,12 +264,8 @@ func calculateChurn(wg *sync.WaitGroup, files analysis.CrawlOrSnapshotFiles, dat
 	newPeersConnected := make(map[time.Time]int)
 	connectionsLost := make(map[time.Time]int)
 	connectionDurations := make(map[peer.ID]time.Duration)
-	timestamps := files.GetTimestamps("known_", dateFormat)
-
-	// sort timestamps
-	sort.Slice(timestamps, func(i, j int) bool {
-		return timestamps[i].Before(timestamps[j])
-	})
+	// sorted, known_ files or snapshot documents
+	timestamps := files.GetSnapshotTimestamps("known", dateFormat)
 
 	// write stats files for points in time: newly known, newly connected, connection lost
 	sfChurn, err := stats.NewFile(outDir + "/churn.dat")
@@ -183,6 +305,7 @@ func calculateChurn(wg *sync.WaitGroup, files analysis.CrawlOrSnapshotFiles, dat
 	}
 
 	lastTimestamp := timestamps[len(timestamps)-1]
+	var lastMaps *analysis.MapsForAnalysis
 	for _, ts := range timestamps {
 		filesForAnalysis, err := analysis.GetFilesForAnalysis(files, time.Time{}, ts, dateFormat)
 		if err != nil {
@@ -193,6 +316,8 @@ func calculateChurn(wg *sync.WaitGroup, files analysis.CrawlOrSnapshotFiles, dat
 			panic(err.Error())
 		}
 
+		lastMaps = mapsForAnalysis
+
 		if !skipTotal {
 			addWholeResult(sfTotal, mapsForAnalysis)
 		}
@@ -239,6 +364,26 @@ func calculateChurn(wg *sync.WaitGroup, files analysis.CrawlOrSnapshotFiles, dat
 
 		sfChurn.AddInts(newPeersKnown[ts], newPeersConnected[ts], connectionsLost[ts])
 	}
+	// attribution of connection attempts to peer sources (cumulative, thus from the last snapshot)
+	if lastMaps != nil && len(lastMaps.PeerSources) > 0 {
+		sourceResults := analysis.CalculateSourceResults(*lastMaps)
+		sourceNames := make([]string, 0, len(sourceResults))
+		for source := range sourceResults {
+			sourceNames = append(sourceNames, source)
+		}
+		sort.Strings(sourceNames)
+		sourceRows := make([][]string, 0, len(sourceNames))
+		for _, source := range sourceNames {
+			result := sourceResults[source]
+			sourceRows = append(sourceRows, []string{source, strconv.Itoa(result.Attempts),
+				strconv.Itoa(result.Successful), strconv.Itoa(result.Failed)})
+		}
+		err = helpers.WriteTsvFile(outDir+"/sources.dat", sourceRows)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
 	// peers still connected at the end
 	for stillConnectedPeer, connStarted := range startOfConnection {
 		connectionDurations[stillConnectedPeer] = lastTimestamp.Sub(connStarted)
@@ -305,6 +450,8 @@ func main() {
 	configValues["SkipTotal"] = ""
 	configValues["DurationBinWidth"] = "10"
 	configValues["SkipDirection"] = ""
+	configValues["GeoDatabases"] = ""
+	configValues["SkipPeerGraph"] = ""
 
 	// load config from command line and display help upon encountering bad options (including -h/--help/Help/help/...)
 	if !helpers.LoadConfig(&configValues, os.Args[1:]) {
@@ -322,7 +469,12 @@ func main() {
 			"SkipChurn                 Do not calculate churn\n" +
 			"SkipTotal                 Do not record total numbers\n" +
 			"DurationBinWidth=<int>    Width of duration histogram bins in minutes\n" +
-			"SkipDirection             Do not calculate connection directions")
+			"SkipDirection             Do not calculate connection directions\n" +
+			"SkipPeerGraph             Do not analyze peer graphs of crawls\n" +
+			"GeoDatabases=<files>      Comma-separated list of MaxMind DB (*.mmdb) or CSV IP\n" +
+			"                          range database files for country and ASN columns in\n" +
+			"                          the comparisons and per-country and per-ASN\n" +
+			"                          breakdowns (default: off)")
 		return
 	}
 
@@ -347,11 +499,24 @@ func main() {
 		durationBinWidth = 10
 	}
 
+	var annotator *annotation.Annotator
+	if configValues["GeoDatabases"] != "" {
+		annotator, err = annotation.NewAnnotator(strings.Split(configValues["GeoDatabases"], ","))
+		if err != nil {
+			panic("Could not load IP databases: " + err.Error())
+		}
+	}
+
 	var wg sync.WaitGroup
 
 	if configValues["SkipComparisons"] == "" {
 		wg.Add(1)
-		go calculateComparisons(&wg, crawlAndSnapshotFiles, dateFormat, outDir)
+		go calculateComparisons(&wg, crawlAndSnapshotFiles, dateFormat, outDir, annotator)
+	}
+
+	if configValues["SkipPeerGraph"] == "" {
+		wg.Add(1)
+		go calculatePeerGraphs(&wg, crawlAndSnapshotFiles, dateFormat, outDir)
 	}
 
 	if configValues["SkipChurn"] == "" {
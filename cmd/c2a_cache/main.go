@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"ipfs-connect2all/analysis"
+	"ipfs-connect2all/helpers"
+	"ipfs-connect2all/input"
+	"os"
+	"strings"
+	"time"
+)
+
+func loadSightings(configValues map[string]string, useSnapshots bool) (map[peer.ID]*analysis.PeerSighting,
+	time.Time) {
+	var files analysis.CrawlOrSnapshotFiles
+	var err error
+	if useSnapshots {
+		files, err = analysis.GetCrawlAndSnapshotFiles(configValues["DHTCrawlDir"], configValues["SnapshotDir"])
+	} else {
+		files, err = analysis.GetFiles(configValues["DHTCrawlDir"])
+	}
+	if err != nil {
+		panic(err.Error())
+	}
+	sightings, newest, err := analysis.CollectPeerSightings(files, configValues["DateFormat"], useSnapshots)
+	if err != nil {
+		panic(err.Error())
+	}
+	return sightings, newest
+}
+
+// whether the peer has been seen within maxAge before the newest crawl or snapshot (maxAge 0: always true)
+func seenWithin(sighting *analysis.PeerSighting, newest time.Time, maxAge time.Duration) bool {
+	if maxAge == 0 {
+		return true
+	}
+	return sighting != nil && !sighting.LastSeen.IsZero() && newest.Sub(sighting.LastSeen) <= maxAge
+}
+
+func writeCache(filename string, nodes []*peer.AddrInfo) {
+	err := input.WriteNodeCache(filename, nodes)
+	if err != nil {
+		panic(err.Error())
+	}
+	// make sure that ipfs-crawler can read what has been written
+	restored, err := input.LoadNodeCache(filename)
+	if err != nil {
+		panic(err.Error())
+	}
+	if len(restored) != len(nodes) {
+		fmt.Printf("Warning: wrote %d entries, but ipfs-crawler restores %d entries from %s\n", len(nodes),
+			len(restored), filename)
+	}
+	fmt.Printf("Wrote %d entries to %s\n", len(nodes), filename)
+}
+
+func main() {
+
+	// default config values
+	var configValues = make(map[string]string)
+	configValues["Command"] = ""
+	configValues["CacheFile"] = "crawls/nodes.cache"
+	configValues["Out"] = ""
+	configValues["MergeFiles"] = ""
+	configValues["DateFormat"] = "06-01-02--15:04:05"
+	configValues["DHTCrawlDir"] = "crawls"
+	configValues["SnapshotDir"] = "snapshots"
+	configValues["MaxAge"] = ""
+	configValues["PruneUnreachable"] = ""
+	configValues["SkipSnapshots"] = ""
+
+	// load config from command line and display help upon encountering bad options (including -h/--help/Help/help/...)
+	if !helpers.LoadConfig(&configValues, os.Args[1:]) || configValues["Command"] == "" {
+		fmt.Println("Usage: c2a_cache Command=<command> [options]\n\n" +
+
+			"Commands:\n" +
+			"list                      Print peer ID and addresses of all entries\n" +
+			"count                     Print number of entries and addresses\n" +
+			"prune                     Remove entries by age (MaxAge) and/or reachability\n" +
+			"                          (PruneUnreachable)\n" +
+			"merge                     Merge CacheFile with all MergeFiles\n" +
+			"build                     Build a cache from the visitedPeers files in DHTCrawlDir\n" +
+			"                          and the successful_ and known_ snapshots in SnapshotDir\n\n" +
+
+			"Options:\n" +
+			"CacheFile=<file>          Node cache file to read (default: crawls/nodes.cache)\n" +
+			"Out=<file>                Node cache file to write (prune, merge, build;\n" +
+			"                          default: CacheFile)\n" +
+			"MergeFiles=<files>        Comma-separated list of node cache files to merge\n" +
+			"DateFormat=<format>       Date format (Go-style) (default: 06-01-02--15:04:05)\n" +
+			"DHTCrawlDir=<dir>         Directory in which the crawl output files are located\n" +
+			"                          (default: crawls)\n" +
+			"SnapshotDir=<dir>         Directory in which the snapshots are located\n" +
+			"                          (default: snapshots)\n" +
+			"MaxAge=<dur>              Only keep resp. add peers seen within <dur> before the\n" +
+			"                          newest crawl or snapshot (default: off)\n" +
+			"PruneUnreachable          Remove peers which were unreachable in the last crawl\n" +
+			"                          containing them\n" +
+			"SkipSnapshots             Only use crawls, not successful_ and known_ snapshots")
+		return
+	}
+
+	outFile := configValues["Out"]
+	if outFile == "" {
+		outFile = configValues["CacheFile"]
+	}
+	var maxAge time.Duration
+	if configValues["MaxAge"] != "" {
+		var err error
+		maxAge, err = time.ParseDuration(configValues["MaxAge"])
+		if err != nil {
+			panic("Invalid MaxAge: " + err.Error())
+		}
+	}
+	useSnapshots := configValues["SkipSnapshots"] == ""
+
+	switch configValues["Command"] {
+	case "list":
+		nodes, err := input.LoadNodeCache(configValues["CacheFile"])
+		if err != nil {
+			panic(err.Error())
+		}
+		for _, node := range nodes {
+			addrs := make([]string, len(node.Addrs))
+			for i, addr := range node.Addrs {
+				addrs[i] = addr.String()
+			}
+			fmt.Printf("%s\t%s\n", node.ID.String(), strings.Join(addrs, ","))
+		}
+
+	case "count":
+		nodes, err := input.LoadNodeCache(configValues["CacheFile"])
+		if err != nil {
+			panic(err.Error())
+		}
+		numAddrs, withoutAddrs := 0, 0
+		for _, node := range nodes {
+			numAddrs += len(node.Addrs)
+			if len(node.Addrs) == 0 {
+				withoutAddrs++
+			}
+		}
+		fmt.Printf("Entries: %d\n", len(nodes))
+		fmt.Printf("Addresses: %d\n", numAddrs)
+		fmt.Printf("Entries without addresses: %d\n", withoutAddrs)
+
+	case "prune":
+		if maxAge == 0 && configValues["PruneUnreachable"] != "1" {
+			panic("Nothing to prune, set MaxAge and/or PruneUnreachable")
+		}
+		nodes, err := input.LoadNodeCache(configValues["CacheFile"])
+		if err != nil {
+			panic(err.Error())
+		}
+		sightings, newest := loadSightings(configValues, useSnapshots)
+		pruned := make([]*peer.AddrInfo, 0, len(nodes))
+		for _, node := range nodes {
+			sighting := sightings[node.ID]
+			if !seenWithin(sighting, newest, maxAge) {
+				continue
+			}
+			if configValues["PruneUnreachable"] == "1" && sighting != nil && sighting.InCrawl &&
+				!sighting.LastCrawlReachable {
+				continue
+			}
+			pruned = append(pruned, node)
+		}
+		fmt.Printf("Removed %d of %d entries\n", len(nodes)-len(pruned), len(nodes))
+		writeCache(outFile, pruned)
+
+	case "merge":
+		if configValues["MergeFiles"] == "" {
+			panic("No MergeFiles given")
+		}
+		caches := make([][]*peer.AddrInfo, 0)
+		for _, filename := range append([]string{configValues["CacheFile"]},
+			strings.Split(configValues["MergeFiles"], ",")...) {
+			nodes, err := input.LoadNodeCache(filename)
+			if err != nil {
+				panic(filename + ": " + err.Error())
+			}
+			caches = append(caches, nodes)
+		}
+		writeCache(outFile, input.MergeNodeCaches(caches...))
+
+	case "build":
+		sightings, newest := loadSightings(configValues, useSnapshots)
+		nodes := make([]*peer.AddrInfo, 0, len(sightings))
+		withoutAddrs := 0
+		for peerID, sighting := range sightings {
+			if sighting.LastSeen.IsZero() || !seenWithin(sighting, newest, maxAge) {
+				continue
+			}
+			// addresses from the crawls, from the known_ snapshots (with SnapshotKnownAddrs) otherwise
+			addrs := sighting.Addrs
+			if len(addrs) == 0 {
+				addrs = sighting.SnapshotAddrs
+			}
+			if len(addrs) == 0 {
+				withoutAddrs++
+				continue
+			}
+			nodes = append(nodes, &peer.AddrInfo{ID: peerID, Addrs: addrs})
+		}
+		if withoutAddrs > 0 {
+			fmt.Printf("Skipped %d peers without addresses in any crawl or known_ snapshot\n", withoutAddrs)
+		}
+		writeCache(outFile, nodes)
+
+	default:
+		panic("Unknown command: " + configValues["Command"])
+	}
+
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"ipfs-connect2all/analysis"
+	"ipfs-connect2all/helpers"
+	"ipfs-connect2all/input"
+	"ipfs-connect2all/stats"
+	"os"
+	"sort"
+	"time"
+)
+
+func main() {
+
+	// default config values
+	var configValues = make(map[string]string)
+	configValues["DateFormat"] = "06-01-02--15:04:05"
+	configValues["DHTCrawlDir"] = "crawls"
+	configValues["OutputDir"] = "crawldiff_result"
+	configValues["SkipDetails"] = ""
+
+	// load config from command line and display help upon encountering bad options (including -h/--help/Help/help/...)
+	if !helpers.LoadConfig(&configValues, os.Args[1:]) {
+		fmt.Println("Usage: c2a_crawldiff [options]\n\n" +
+
+			"Options:\n" +
+			"DateFormat=<format>       Date format (Go-style) (default: 06-01-02--15:04:05)\n" +
+			"DHTCrawlDir=<dir>         Directory in which the crawl output files are located\n" +
+			"                          (default: crawls)\n" +
+			"OutputDir=<dir>           Directory to which the output files will be saved\n" +
+			"                          (default: crawldiff_result)\n" +
+			"SkipDetails               Do not write the per-crawl CSV detail files")
+		return
+	}
+
+	// create output dir if it does not exist yet
+	err := helpers.CheckOrCreateDir(configValues["OutputDir"])
+	if err != nil {
+		panic("Could not create output dir: " + err.Error())
+	}
+
+	crawlFiles, err := analysis.GetFiles(configValues["DHTCrawlDir"])
+	if err != nil {
+		panic(err.Error())
+	}
+	dateFormat := configValues["DateFormat"]
+	outDir := configValues["OutputDir"]
+
+	timestamps := crawlFiles.GetTimestamps("visitedPeers_", dateFormat)
+
+	// sort timestamps
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i].Before(timestamps[j])
+	})
+
+	// analysis does not make sense with less than 2 crawls
+	if len(timestamps) < 2 {
+		panic("Cannot compare crawls with less than 2 crawls")
+	}
+
+	sfDiff, err := stats.NewFile(outDir + "/crawldiff.dat")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer sfDiff.FlushAndClose()
+
+	var previousPeers map[peer.ID]*input.VisitedPeer
+	var previousTimestamp time.Time
+	for _, ts := range timestamps {
+		visitedPeersFile := crawlFiles.GetClosest("visitedPeers_", ts, dateFormat)
+		if visitedPeersFile == nil {
+			continue
+		}
+		currentPeers, err := input.LoadVisitedPeers(visitedPeersFile.GetPath())
+		if err != nil {
+			fmt.Printf("Skipping crawl %s, could not load visited peers: %s\n", visitedPeersFile.Filename, err)
+			continue
+		}
+
+		if previousPeers != nil {
+			result := analysis.CalculateCrawlDiff(previousPeers, currentPeers)
+			sfDiff.AddFloats(ts.Sub(previousTimestamp).Minutes(), float64(result.PreviousPeers),
+				float64(result.CurrentPeers), float64(result.Appeared), float64(result.Disappeared),
+				float64(result.BecameReachable), float64(result.BecameUnreachable),
+				float64(result.AgentVersionChanged), float64(result.AddressesChanged))
+
+			if configValues["SkipDetails"] == "" {
+				err = helpers.WriteCsvFile(outDir+"/crawldiff_"+ts.Format(dateFormat)+".csv", result.Rows())
+				if err != nil {
+					panic(err.Error())
+				}
+			}
+		}
+
+		previousPeers = currentPeers
+		previousTimestamp = ts
+	}
+
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"ipfs-connect2all/analysis"
+	"ipfs-connect2all/helpers"
+	"ipfs-connect2all/input"
+	"ipfs-connect2all/stats"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+func main() {
+
+	// default config values
+	var configValues = make(map[string]string)
+	configValues["DateFormat"] = "06-01-02--15:04:05"
+	configValues["WantlistDir"] = "wantlists"
+	configValues["OutputDir"] = "wantlist_result"
+	configValues["WantlistInterval"] = "1m"
+	configValues["Cumulative"] = ""
+	configValues["TopCids"] = "1000"
+	configValues["PopularityCids"] = "10"
+	configValues["OverlapPeers"] = "50"
+	configValues["SnapshotDir"] = ""
+	configValues["DHTCrawlDir"] = ""
+
+	// load config from command line and display help upon encountering bad options (including -h/--help/Help/help/...)
+	if !helpers.LoadConfig(&configValues, os.Args[1:]) {
+		fmt.Println("Usage: c2a_wantlists [options]\n\n" +
+
+			"Options:\n" +
+			"DateFormat=<format>       Date format (Go-style) (default: 06-01-02--15:04:05)\n" +
+			"WantlistDir=<dir>         Directory in which the wantlistLog files are located\n" +
+			"                          (default: wantlists)\n" +
+			"OutputDir=<dir>           Directory to which the output files will be saved\n" +
+			"                          (default: wantlist_result)\n" +
+			"WantlistInterval=<dur>    Wantlist snapshot interval used for recording the\n" +
+			"                          files (default: 1m)\n" +
+			"Cumulative                Files have been recorded with DoNotResetWantlistCache\n" +
+			"TopCids=<value>           Number of most requested CIDs in cids.dat, 0 for all\n" +
+			"                          (default: 1000)\n" +
+			"PopularityCids=<value>    Number of most requested CIDs tracked over time\n" +
+			"                          (default: 10)\n" +
+			"OverlapPeers=<value>      Number of most active peers compared with each other\n" +
+			"                          (default: 50)\n" +
+			"SnapshotDir=<dir>         Join with the connected peers snapshots in <dir>\n" +
+			"                          (activity by connection direction and age, and by\n" +
+			"                          agent version if recorded)\n" +
+			"DHTCrawlDir=<dir>         Join with the agent versions of the crawls in <dir>\n" +
+			"                          (activity by agent version)")
+		return
+	}
+
+	// create output dir if it does not exist yet
+	err := helpers.CheckOrCreateDir(configValues["OutputDir"])
+	if err != nil {
+		panic("Could not create output dir: " + err.Error())
+	}
+
+	wantlistInterval, err := time.ParseDuration(configValues["WantlistInterval"])
+	if err != nil {
+		panic("Invalid WantlistInterval: " + err.Error())
+	}
+	topCids, err := strconv.Atoi(configValues["TopCids"])
+	if err != nil || topCids < 0 {
+		topCids = 1000
+	}
+	popularityCids, err := strconv.Atoi(configValues["PopularityCids"])
+	if err != nil || popularityCids < 0 {
+		popularityCids = 10
+	}
+	overlapPeers, err := strconv.Atoi(configValues["OverlapPeers"])
+	if err != nil || overlapPeers < 0 {
+		overlapPeers = 50
+	}
+	dateFormat := configValues["DateFormat"]
+	outDir := configValues["OutputDir"]
+
+	wantlistFiles, err := analysis.GetFiles(configValues["WantlistDir"])
+	if err != nil {
+		panic(err.Error())
+	}
+	timestamps := wantlistFiles.GetTimestamps("wantlistLog_", dateFormat)
+
+	// sort timestamps
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i].Before(timestamps[j])
+	})
+
+	if len(timestamps) == 0 {
+		panic("No wantlistLog files found")
+	}
+
+	// connection and agent version data for joining (optional)
+	var connections *analysis.ConnectionHistory
+	if configValues["SnapshotDir"] != "" {
+		connections, err = loadConnectionHistory(configValues["SnapshotDir"], dateFormat)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	var agentVersions *analysis.AgentVersionHistory
+	if configValues["DHTCrawlDir"] != "" {
+		agentVersions, err = loadAgentVersionHistory(configValues["DHTCrawlDir"], dateFormat)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	activityAnalysis := analysis.NewWantlistActivityAnalysis(connections, agentVersions)
+
+	wantlistAnalysis := analysis.NewWantlistAnalysis(configValues["Cumulative"] == "1")
+	for _, ts := range timestamps {
+		wantlistFile := wantlistFiles.GetClosest("wantlistLog_", ts, dateFormat)
+		if wantlistFile == nil {
+			continue
+		}
+		_, err := input.ReadWantlistLog(wantlistFile.GetPath(), func(logPeer helpers.WantlistLogPeer) error {
+			counts := wantlistAnalysis.AddPeer(ts, logPeer)
+			if counts.Total() > 0 {
+				activityAnalysis.AddPeer(ts, logPeer.PeerID, counts)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Could not read %s completely, using the peers read so far: %s\n", wantlistFile.Filename,
+				err)
+		}
+		wantlistAnalysis.FinishSnapshot(ts)
+	}
+
+	// requests per snapshot
+	sfSnapshots, err := stats.NewFile(outDir + "/wanttypes.dat")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer sfSnapshots.FlushAndClose()
+	for _, snapshot := range wantlistAnalysis.Snapshots {
+		sfSnapshots.AddFloats(float64(snapshot.Peers), float64(snapshot.Cids), float64(snapshot.Counts.WantHave),
+			float64(snapshot.Counts.WantBlock), snapshot.Counts.WantHaveShare())
+	}
+
+	// most requested CIDs
+	cidResults := wantlistAnalysis.CidResults()
+	topCidResults := cidResults
+	if topCids > 0 && len(topCidResults) > topCids {
+		topCidResults = topCidResults[:topCids]
+	}
+	err = helpers.WriteTsvFile(outDir+"/cids.dat", analysis.CidRows(topCidResults))
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// structure of the requested CIDs, overall and per snapshot
+	err = helpers.WriteTsvFile(outDir+"/cidstructure.dat", wantlistAnalysis.CidStructureRows(cidResults))
+	if err != nil {
+		panic(err.Error())
+	}
+	sfCidStructure, err := stats.NewFile(outDir + "/cidstructure_time.dat")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer sfCidStructure.FlushAndClose()
+	for _, snapshot := range wantlistAnalysis.Snapshots {
+		sfCidStructure.AddInts(snapshot.Structure.Ints()...)
+	}
+
+	// distribution of the number of requesting peers per CID
+	cidsPerPeerCount := make(map[int]int)
+	for _, result := range cidResults {
+		cidsPerPeerCount[result.Peers]++
+	}
+	peerCounts := make([]int, 0, len(cidsPerPeerCount))
+	for peerCount := range cidsPerPeerCount {
+		peerCounts = append(peerCounts, peerCount)
+	}
+	sort.Ints(peerCounts)
+	sfCidPeers, err := stats.NewFile(outDir + "/cidpeers.dat")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer sfCidPeers.FlushAndClose()
+	for _, peerCount := range peerCounts {
+		sfCidPeers.AddInts(peerCount, cidsPerPeerCount[peerCount])
+	}
+
+	// requesting peers
+	peerResults := wantlistAnalysis.PeerResults()
+	err = helpers.WriteTsvFile(outDir+"/peers.dat", wantlistAnalysis.PeerRows(peerResults, wantlistInterval))
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// popularity of the most requested CIDs over time
+	if popularityCids > len(cidResults) {
+		popularityCids = len(cidResults)
+	}
+	popularityRows := make([][]string, popularityCids)
+	for i := 0; i < popularityCids; i++ {
+		popularityRows[i] = []string{strconv.Itoa(i + 1), cidResults[i].Cid}
+	}
+	err = helpers.WriteTsvFile(outDir+"/popularity_cids.dat", popularityRows)
+	if err != nil {
+		panic(err.Error())
+	}
+	sfPopularity, err := stats.NewFile(outDir + "/popularity.dat")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer sfPopularity.FlushAndClose()
+	for _, snapshot := range wantlistAnalysis.Snapshots {
+		values := make([]int, popularityCids)
+		for i := 0; i < popularityCids; i++ {
+			values[i] = snapshot.CidPeers[cidResults[i].Cid]
+		}
+		sfPopularity.AddInts(values...)
+	}
+
+	// overlap of requests between the most active peers
+	if overlapPeers > len(peerResults) {
+		overlapPeers = len(peerResults)
+	}
+	overlapPeerIDs := make([]string, overlapPeers)
+	for i := 0; i < overlapPeers; i++ {
+		overlapPeerIDs[i] = peerResults[i].PeerID
+	}
+	err = helpers.WriteTsvFile(outDir+"/overlap.dat",
+		analysis.OverlapRows(wantlistAnalysis.Overlaps(overlapPeerIDs)))
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// request activity by agent version, connection direction and connection age
+	if activityAnalysis.HasAgentVersions() {
+		err = helpers.WriteTsvFile(outDir+"/activity_agent.dat",
+			analysis.WantlistActivityRows(activityAnalysis.ByAgentVersion, nil))
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	if connections != nil {
+		err = helpers.WriteTsvFile(outDir+"/activity_direction.dat",
+			analysis.WantlistActivityRows(activityAnalysis.ByDirection, nil))
+		if err != nil {
+			panic(err.Error())
+		}
+		err = helpers.WriteTsvFile(outDir+"/activity_age.dat",
+			analysis.WantlistActivityRows(activityAnalysis.ByConnectionAge, analysis.ConnectionAgeGroups))
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	fmt.Printf("Snapshots: %d\n", len(wantlistAnalysis.Snapshots))
+	fmt.Printf("Requesting peers: %d\n", len(peerResults))
+	fmt.Printf("Requested CIDs: %d\n", len(cidResults))
+
+}
+
+// load all connected peers snapshots (CSV files or snapshot documents) in chronological order
+func loadConnectionHistory(snapshotDir string, dateFormat string) (*analysis.ConnectionHistory, error) {
+	snapshotFiles, err := analysis.GetFiles(snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+	timestamps := snapshotFiles.GetSnapshotTimestamps("connected", dateFormat)
+	if len(timestamps) == 0 {
+		return nil, errors.New("No connected peers snapshot files found")
+	}
+	ret := analysis.NewConnectionHistory()
+	for _, ts := range timestamps {
+		connectedPeers, err := snapshotFiles.LoadSnapshotConnectedPeers(ts, dateFormat)
+		if err != nil {
+			fmt.Printf("Skipping snapshot %s, could not load connected peers: %s\n", ts.Format(dateFormat), err)
+			continue
+		}
+		ret.AddSnapshot(ts, connectedPeers)
+	}
+	return ret, nil
+}
+
+// load the agent versions of all crawls in chronological order
+func loadAgentVersionHistory(crawlDir string, dateFormat string) (*analysis.AgentVersionHistory, error) {
+	crawlFiles, err := analysis.GetFiles(crawlDir)
+	if err != nil {
+		return nil, err
+	}
+	timestamps := crawlFiles.GetTimestamps("visitedPeers_", dateFormat)
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i].Before(timestamps[j])
+	})
+	if len(timestamps) == 0 {
+		return nil, errors.New("No visitedPeers files found")
+	}
+	ret := analysis.NewAgentVersionHistory()
+	for _, ts := range timestamps {
+		visitedPeersFile := crawlFiles.GetClosest("visitedPeers_", ts, dateFormat)
+		if visitedPeersFile == nil {
+			continue
+		}
+		visitedPeers, err := input.LoadVisitedPeers(visitedPeersFile.GetPath())
+		if err != nil {
+			fmt.Printf("Skipping crawl %s, could not load visited peers: %s\n", visitedPeersFile.Filename, err)
+			continue
+		}
+		ret.AddCrawl(ts, visitedPeers)
+	}
+	return ret, nil
+}
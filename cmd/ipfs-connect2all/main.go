@@ -5,14 +5,18 @@ import (
 	"context"
 	"fmt"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"ipfs-connect2all/annotation"
+	"ipfs-connect2all/database"
 	"ipfs-connect2all/helpers"
 	"ipfs-connect2all/input"
 	"ipfs-connect2all/stats"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -28,19 +32,54 @@ func main() {
 	configValues["MeasureConnections"] = ""
 	configValues["DHTPeers"] = ""
 	configValues["DHTConnsPerSec"] = "5"
+	configValues["DHTPeersWatchDir"] = ""
+	configValues["DHTPeersWatchInterval"] = "30s"
+	configValues["DHTPeersWatchState"] = "processedVisitedPeers.txt"
 	configValues["Snapshots"] = ""
 	configValues["DateFormat"] = "06-01-02--15:04:05"
 	configValues["StatsInterval"] = "5s"
 	configValues["SnapshotInterval"] = "10m"
+	configValues["SnapshotKnownAddrs"] = ""
+	configValues["SnapshotCompression"] = "none"
+	configValues["SnapshotFormat"] = "csv"
+	configValues["SnapshotShardByDay"] = ""
+	configValues["SnapshotHourlyAfter"] = "0"
+	configValues["SnapshotMaxSize"] = "0"
 	configValues["DHTCrawlInterval"] = ""
+	configValues["DHTCrawler"] = "ipfs-crawler"
 	configValues["DHTCrawlOut"] = "crawls"
 	configValues["DHTPreImages"] = "precomputed_hashes/preimages.csv"
 	configValues["DHTQueueSize"] = "64384"
 	configValues["DHTCacheFile"] = "crawls/nodes.cache"
+	configValues["DHTCrawlRetries"] = "2"
+	configValues["DHTCrawlRetryDelay"] = "5m"
+	configValues["DHTCrawlStatus"] = "crawlStatus.json"
+	configValues["BuiltinCrawlMode"] = "random"
+	configValues["BuiltinCrawlQueries"] = "256"
+	configValues["BuiltinCrawlConcurrency"] = "16"
+	configValues["BuiltinCrawlTimeout"] = "30s"
 	configValues["WantlistSnapshots"] = ""
 	configValues["WantlistInterval"] = "1m"
 	configValues["DoNotResetWantlistCache"] = ""
 	configValues["WantlistOfPeers"] = ""
+	configValues["WantlistOfPeersFile"] = ""
+	configValues["WantlistSampleRate"] = "1"
+	configValues["WantlistGzip"] = ""
+	configValues["WantlistEventLog"] = ""
+	configValues["WantlistEventInterval"] = "1s"
+	configValues["WantlistEventRotate"] = "1h"
+	configValues["WantlistEventGzip"] = ""
+	configValues["DialFilterPrivate"] = ""
+	configValues["DialFilterBlocklist"] = ""
+	configValues["DialFilterAllowlist"] = ""
+	configValues["DialFilterTransports"] = ""
+	configValues["DialFilterCircuit"] = ""
+	configValues["PeerAllowlist"] = ""
+	configValues["PeerDenylist"] = ""
+	configValues["UnfilteredBootstrap"] = ""
+	configValues["GeoDatabases"] = ""
+	configValues["DialTimeout"] = ""
+	configValues["Database"] = ""
 
 	// load config from command line and display help upon encountering bad options (including -h/--help/Help/help/...)
 	if !helpers.LoadConfig(&configValues, os.Args[1:]) {
@@ -59,35 +98,104 @@ func main() {
 			"                          <x>8080, <x> in range 0 to 5, default: 0 [no prefix])\n" +
 			"DateFormat=<format>       Date format (Go-style) (default: 06-01-02--15:04:05)\n" +
 			"MeasureConnections=<file> Track average connection time and write to <file> \n" +
-			"                          (default: no tracking, reduces concurrency)\n\n" +
+			"                          (default: no tracking, reduces concurrency)\n" +
+			"DialTimeout=<dur>         Timeout for each connection attempt (default: none,\n" +
+			"                          i.e., libp2p defaults)\n" +
+			"Database=<file>           Also write stats, snapshots, connection state changes\n" +
+			"                          and crawls to database file <file> (default: off)\n\n" +
+
+			"Dial filter options:\n" +
+			"DialFilterPrivate         Do not dial private, loopback and link-local addresses\n" +
+			"DialFilterBlocklist=<file> Do not dial addresses in the CIDR ranges listed in\n" +
+			"                          <file> (one range or IP address per line)\n" +
+			"DialFilterAllowlist=<file> Only dial addresses in the CIDR ranges listed in\n" +
+			"                          <file> (one range or IP address per line)\n" +
+			"DialFilterTransports=<t>  Comma-separated list of transports to dial (tcp, udp,\n" +
+			"                          quic, ws, wss, ...) (default: all)\n" +
+			"DialFilterCircuit         Do not dial relay (/p2p-circuit) addresses\n\n" +
+
+			"Peer filter options (reloaded on SIGHUP):\n" +
+			"PeerAllowlist=<file>      Only dial, snapshot and log wantlists of the peers\n" +
+			"                          listed in <file> (one peer ID per line)\n" +
+			"PeerDenylist=<file>       Never connect to, snapshot or log wantlists of the\n" +
+			"                          peers listed in <file> (one peer ID per line)\n" +
+			"UnfilteredBootstrap       Dial bootstrap peers even if excluded by the peer or\n" +
+			"                          dial filters (default: bootstrap peers are filtered)\n\n" +
 
 			"Snapshot options:\n" +
 			"Snapshots=<dir>           Write snapshots of currently known/... peers to files\n" +
 			"                          in <dir> (no trailing /, default: off)\n" +
-			"SnapshotInterval=<dur>    Snapshot interval (default: 10m)\n\n" +
+			"SnapshotInterval=<dur>    Snapshot interval (default: 10m)\n" +
+			"GeoDatabases=<files>      Comma-separated list of MaxMind DB (*.mmdb) or CSV IP\n" +
+			"                          range database files for adding country and ASN\n" +
+			"                          columns to known peers snapshots (default: off)\n" +
+			"SnapshotKnownAddrs        Add the addresses of the known peers and derived\n" +
+			"                          columns to known peers snapshots\n" +
+			"SnapshotCompression=<c>   Compress snapshot files: none, gzip or zstd\n" +
+			"                          (default: none)\n" +
+			"SnapshotFormat=<format>   csv (one file per peer list) or json (one document\n" +
+			"                          per snapshot) (default: csv)\n" +
+			"SnapshotShardByDay        Write snapshots to per-day subdirectories\n" +
+			"                          (YYYY-MM-DD) of the snapshot directory\n" +
+			"SnapshotHourlyAfter=<days> Only keep one snapshot per hour after <days> days\n" +
+			"                          (default: 0, i.e., keep all)\n" +
+			"SnapshotMaxSize=<MB>      Delete the oldest snapshots if all snapshots take\n" +
+			"                          more than <MB> MB (default: 0, i.e., unlimited)\n\n" +
 
 			"DHT scan options:\n" +
 			"DHTPeers=<file>           Load visited peers from DHT crawl from \n" +
 			"                          visitedPeers*.json file <file>\n" +
 			"DHTConnsPerSec=<value>    Initiate <value> connections to peers from DHT crawl\n" +
-			"                          per second (default: 5)\n\n" +
+			"                          per second (default: 5)\n" +
+			"DHTPeersWatchDir=<dir>    Load visited peers from all visitedPeers_*.json files\n" +
+			"                          appearing in <dir> (default: off)\n" +
+			"DHTPeersWatchInterval=<dur> Interval for checking <dir> for new files\n" +
+			"                          (default: 30s)\n" +
+			"DHTPeersWatchState=<file> Record processed files in <file> to skip them after\n" +
+			"                          a restart (default: processedVisitedPeers.txt)\n\n" +
 
 			"ipfs-crawler integration options:\n" +
 			"DHTCrawlInterval=<dur>    Crawl the DHT automatically in intervals of <dur>\n" +
 			"                          (default: off)\n" +
+			"DHTCrawler=<crawler>      ipfs-crawler or builtin (lookups of this node's\n" +
+			"                          DHT, no preimages needed; default: ipfs-crawler)\n" +
 			"DHTCrawlOut=<dir>         Directory for saving the output files of DHT crawls\n" +
 			"                          (default: crawl)\n" +
 			"DHTPreImages=<file>       File with preimages for DHT crawls\n" +
 			"                          (default: precomputed_hashes/preimages.csv)\n" +
 			"DHTQueueSize=<size>       Queue size for DHT crawls (default: 64384)\n" +
 			"DHTCacheFile=<file>       Cache file for DHT crawls (default:\n" +
-			"                          crawls/nodes.cache; empty to disable caching)\n\n" +
+			"                          crawls/nodes.cache; empty to disable caching)\n" +
+			"DHTCrawlRetries=<value>   Retries after a failed DHT crawl (default: 2)\n" +
+			"DHTCrawlRetryDelay=<dur>  Delay before retrying a failed DHT crawl\n" +
+			"                          (default: 5m)\n" +
+			"DHTCrawlStatus=<file>     Write status of DHT crawls to <file>\n" +
+			"                          (default: crawlStatus.json)\n" +
+			"BuiltinCrawlMode=<mode>   random (random keys) or buckets (random keys in\n" +
+			"                          buckets of found peers; default: random)\n" +
+			"BuiltinCrawlQueries=<value> Lookups per built-in crawl (default: 256)\n" +
+			"BuiltinCrawlConcurrency=<value> Concurrent lookups of built-in crawls\n" +
+			"                          (default: 16)\n" +
+			"BuiltinCrawlTimeout=<dur> Timeout for each lookup of built-in crawls\n" +
+			"                          (default: 30s)\n\n" +
 			"Wantlist evaluation options:\n" +
 			"WantlistSnapshots=<dir>   Write snapshots of collected wantlists to files \n" +
 			"                          in <dir> (no trailing /, default: off)\n" +
 			"WantlistInterval=<dur>    Wantlist snapshot interval (default: 1m)\n" +
 			"DoNotResetWantlistCache   Do not reset wantlist cache after writing snapshot\n" +
-			"WantlistOfPeers=<ids>     Comma-separated list of source peer IDs (def.: all)")
+			"WantlistOfPeers=<ids>     Comma-separated list of source peer IDs (def.: all)\n" +
+			"WantlistOfPeersFile=<file> Additional source peer IDs listed in <file> (one\n" +
+			"                          peer ID per line, reloaded on SIGHUP)\n" +
+			"WantlistSampleRate=<value> Share of source peers (by hashed peer ID) whose\n" +
+			"                          wantlists are logged (default: 1, i.e., all)\n" +
+			"WantlistGzip              Compress wantlist snapshots with gzip (*.json.gz)\n" +
+			"WantlistEventLog=<prefix> Write the requests collected in the wantlist cache\n" +
+			"                          to JSONL files <prefix>_<date>.jsonl (default: off,\n" +
+			"                          needs WantlistSnapshots)\n" +
+			"WantlistEventInterval=<dur> Poll interval of the wantlist cache for the event\n" +
+			"                          log (default: 1s)\n" +
+			"WantlistEventRotate=<dur> Start a new event log file every <dur> (default: 1h)\n" +
+			"WantlistEventGzip         Compress event log files with gzip (*.jsonl.gz)")
 		return
 	}
 
@@ -101,10 +209,6 @@ func main() {
 	if err != nil {
 		connMgrHighWater = 0
 	}
-	dhtConnsPerSec, err := strconv.Atoi(configValues["DHTConnsPerSec"])
-	if err != nil {
-		dhtConnsPerSec = 5
-	}
 	portPrefixNum, err := strconv.Atoi(configValues["PortPrefix"])
 	if err != nil || portPrefixNum < 0 || portPrefixNum > 5 {
 		portPrefixNum = 0
@@ -113,10 +217,32 @@ func main() {
 	if portPrefixNum == 0 {
 		portPrefixStr = ""
 	}
+	snapshotCompression := configValues["SnapshotCompression"]
+	err = helpers.CheckCompression(snapshotCompression)
+	if err != nil {
+		panic("Invalid SnapshotCompression: " + err.Error())
+	}
+	snapshotFormat := configValues["SnapshotFormat"]
+	if snapshotFormat != helpers.SnapshotFormatCsv && snapshotFormat != helpers.SnapshotFormatJson {
+		panic("Invalid SnapshotFormat: " + snapshotFormat)
+	}
+	var snapshotRetention helpers.SnapshotRetention
+	snapshotHourlyAfter, err := strconv.Atoi(configValues["SnapshotHourlyAfter"])
+	if err == nil && snapshotHourlyAfter > 0 {
+		snapshotRetention.HourlyAfter = time.Duration(snapshotHourlyAfter) * 24 * time.Hour
+	}
+	snapshotMaxSize, err := strconv.ParseInt(configValues["SnapshotMaxSize"], 10, 64)
+	if err == nil && snapshotMaxSize > 0 {
+		snapshotRetention.MaxTotalSize = snapshotMaxSize * 1024 * 1024
+	}
 	wantlistInterval, err := time.ParseDuration(configValues["WantlistInterval"])
 	if err != nil {
 		wantlistInterval = time.Minute
 	}
+	dialTimeout, err := time.ParseDuration(configValues["DialTimeout"])
+	if err != nil || dialTimeout < 0 {
+		dialTimeout = 0
+	}
 	wantlistOfPeers := make(map[peer.ID]bool)
 	if configValues["WantlistOfPeers"] != "" {
 		wopStrings := strings.Split(configValues["WantlistOfPeers"], ",")
@@ -129,15 +255,111 @@ func main() {
 		}
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	dialFilter := helpers.NewDialFilter()
+	dialFilter.SkipPrivate = configValues["DialFilterPrivate"] == "1"
+	dialFilter.DropCircuit = configValues["DialFilterCircuit"] == "1"
+	if configValues["DialFilterBlocklist"] != "" {
+		dialFilter.Blocklist, err = helpers.LoadCIDRList(configValues["DialFilterBlocklist"])
+		if err != nil {
+			panic("Could not load dial filter blocklist: " + err.Error())
+		}
+	}
+	if configValues["DialFilterAllowlist"] != "" {
+		dialFilter.Allowlist, err = helpers.LoadCIDRList(configValues["DialFilterAllowlist"])
+		if err != nil {
+			panic("Could not load dial filter allowlist: " + err.Error())
+		}
+	}
+	if configValues["DialFilterTransports"] != "" {
+		dialFilter.Transports = make(map[string]bool)
+		for _, t := range strings.Split(configValues["DialFilterTransports"], ",") {
+			dialFilter.Transports[strings.TrimSpace(t)] = true
+		}
+	}
+
+	peerFilter, err := input.NewPeerFilter(configValues["PeerAllowlist"], configValues["PeerDenylist"])
+	if err != nil {
+		panic(err.Error())
+	}
+	wantlistSampleRate, err := strconv.ParseFloat(configValues["WantlistSampleRate"], 64)
+	if err != nil {
+		panic("Invalid WantlistSampleRate: " + err.Error())
+	}
+	wantlistFilter, err := input.NewWantlistFilter(wantlistOfPeers, configValues["WantlistOfPeersFile"],
+		wantlistSampleRate, peerFilter)
+	if err != nil {
+		panic(err.Error())
+	}
+	// peers of the bitswap wantlist cache, updated after a reload
+	wantlistCacheFilter := helpers.NewWantlistCacheFilter(wantlistFilter)
+	if peerFilter.IsActive() || wantlistFilter.IsReloadable() {
+		go func() {
+			sighup := make(chan os.Signal, 1)
+			signal.Notify(sighup, syscall.SIGHUP)
+			for range sighup {
+				if peerFilter.IsActive() {
+					err := peerFilter.Reload()
+					if err != nil {
+						log.Printf("Could not reload peer filter, keeping old lists: %s", err)
+					} else {
+						log.Println("Peer filter reloaded")
+					}
+				}
+				if wantlistFilter.IsReloadable() {
+					err := wantlistFilter.Reload()
+					if err != nil {
+						log.Printf("Could not reload wantlist filter, keeping old list: %s", err)
+					} else {
+						log.Printf("Wantlist filter reloaded (%d peers)", len(wantlistFilter.Metadata().Peers))
+					}
+				}
+				wantlistCacheFilter.Refresh()
+			}
+		}()
+	}
+
+	var db *database.DB
+	if configValues["Database"] != "" {
+		db, err = database.Open(configValues["Database"])
+		if err != nil {
+			panic(err.Error())
+		}
+		defer func() {
+			if err := db.Close(); err != nil {
+				log.Printf("Error: Could not close database: %s", err)
+			}
+		}()
+	}
 
-	ipfs := helpers.InitIpfs(ctx, configValues["ConnMgrType"], connMgrHighWater, portPrefixStr)
+	// record the effective timeout, 0 means libp2p defaults
+	log.Printf("Dial timeout: %s", dialTimeout)
+	if db != nil {
+		err = db.PutMeta("dialTimeout", []byte(dialTimeout.String()))
+		if err != nil {
+			log.Printf("Error: Could not write dial timeout to database: %s", err)
+		}
+	}
 
-	if configValues["WantlistSnapshots"] != "" {
-		helpers.InitWantlistAnalysis(configValues["WantlistSnapshots"], wantlistInterval,
-			configValues["DoNotResetWantlistCache"] != "1", configValues["DateFormat"], wantlistOfPeers)
+	var annotator *annotation.Annotator
+	if configValues["GeoDatabases"] != "" {
+		annotator, err = annotation.NewAnnotator(strings.Split(configValues["GeoDatabases"], ","))
+		if err != nil {
+			panic("Could not load IP databases: " + err.Error())
+		}
+	}
+
+	crawlStatus := input.NewCrawlStatus()
+	if db != nil {
+		crawlStatus = input.NewCrawlStatusWithCallback(func(result *input.CrawlResult) {
+			if err := db.AddCrawl(result.DatabaseRecord()); err != nil {
+				log.Printf("failed to write crawl to database: %s", err)
+			}
+		})
 	}
+	wantlistStatus := helpers.NewWantlistStatus()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// set bootstrap nodes
 	bootstrapNodes := []string{
@@ -156,85 +378,152 @@ func main() {
 		// TODO add more nodes, e.g., from DHT scan?
 	}
 
+	// bootstrap peers
+	bootstrapPeerInfos, err := helpers.MakePeerAddrInfoMap(bootstrapNodes)
+	if err != nil {
+		panic("Could not read list of bootstrap peers: " + err.Error())
+	}
+
+	// enforce the dial filter for all dials, including addresses from the peerstore, and the peer denylist for all
+	// connections
+	var dialGater *helpers.DialGater
+	if dialFilter.IsActive() || peerFilter.IsActive() {
+		var unfilteredPeers map[peer.ID]bool
+		if configValues["UnfilteredBootstrap"] == "1" {
+			unfilteredPeers = make(map[peer.ID]bool, len(bootstrapPeerInfos))
+			for peerID := range bootstrapPeerInfos {
+				unfilteredPeers[peerID] = true
+			}
+		}
+		dialGater = helpers.NewDialGater(dialFilter, peerFilter, unfilteredPeers)
+	}
+	ipfs, node := helpers.InitIpfs(ctx, configValues["ConnMgrType"], connMgrHighWater, portPrefixStr, dialGater)
+
+	var wantlistEventLog *helpers.WantlistEventLog
+	if configValues["WantlistEventLog"] != "" {
+		if configValues["WantlistSnapshots"] == "" {
+			log.Println("Wantlist event log disabled: needs WantlistSnapshots")
+		} else {
+			wantlistEventInterval, err := time.ParseDuration(configValues["WantlistEventInterval"])
+			if err != nil || wantlistEventInterval <= 0 {
+				wantlistEventInterval = time.Second
+			}
+			wantlistEventRotate, err := time.ParseDuration(configValues["WantlistEventRotate"])
+			if err != nil || wantlistEventRotate <= 0 {
+				wantlistEventRotate = time.Hour
+			}
+			wantlistEventLog = helpers.NewWantlistEventLog(configValues["WantlistEventLog"],
+				configValues["DateFormat"], wantlistEventRotate, configValues["WantlistEventGzip"] == "1",
+				wantlistEventInterval, wantlistFilter.IsAllowed)
+			go wantlistEventLog.Run()
+			defer wantlistEventLog.Close()
+		}
+	}
+
+	if configValues["WantlistSnapshots"] != "" {
+		helpers.InitWantlistAnalysis(configValues["WantlistSnapshots"], wantlistInterval,
+			configValues["DoNotResetWantlistCache"] != "1", configValues["DateFormat"], wantlistCacheFilter,
+			node.PeerHost.Network(), configValues["WantlistGzip"] == "1", wantlistStatus, wantlistEventLog)
+	}
+
 	// manage connections to track them
 	connectionsMutex := &sync.Mutex{}
 	connectionsInitiated := make(map[peer.ID]bool)
 	connectionsFailed := make(map[peer.ID]bool)
 	connectionsEstablished := make(map[peer.ID]bool)
 	connectionsSuccessful := make(map[peer.ID]bool)
+	connectionsTimedOut := make(map[peer.ID]bool)
+	// peer source of the (first) connection attempt
+	connectionSources := make(map[peer.ID]string)
 
-	checkConnectionAndSetInitiated := func(peerID peer.ID) bool {
+	checkConnectionAndSetInitiated := func(peerID peer.ID, source string) bool {
 		connectionsMutex.Lock()
 		defer connectionsMutex.Unlock()
-		if connectionsInitiated[peerID] || connectionsFailed[peerID] {
+		if connectionsInitiated[peerID] || connectionsFailed[peerID] || connectionsTimedOut[peerID] {
 			return false
 		}
 		connectionsInitiated[peerID] = true
+		if _, hasSource := connectionSources[peerID]; !hasSource {
+			connectionSources[peerID] = source
+		}
+		if db != nil {
+			db.AddTransition(peerID.String(), database.StateInitiated, source)
+		}
 		return true
 	}
 
-	setConnectionInitiated := func(peerID peer.ID) {
+	setConnectionFailed := func(peerID peer.ID) {
 		connectionsMutex.Lock()
-		connectionsInitiated[peerID] = true
+		delete(connectionsInitiated, peerID)
+		delete(connectionsEstablished, peerID)
+		connectionsFailed[peerID] = true
 		connectionsMutex.Unlock()
+		if db != nil {
+			db.AddTransition(peerID.String(), database.StateFailed, "")
+		}
 	}
 
-	setConnectionFailed := func(peerID peer.ID) {
+	setConnectionTimedOut := func(peerID peer.ID) {
 		connectionsMutex.Lock()
 		delete(connectionsInitiated, peerID)
 		delete(connectionsEstablished, peerID)
-		connectionsFailed[peerID] = true
+		connectionsTimedOut[peerID] = true
 		connectionsMutex.Unlock()
+		if db != nil {
+			db.AddTransition(peerID.String(), database.StateTimedOut, "")
+		}
 	}
 
 	setConnectionEstablished := func(peerID peer.ID) {
 		connectionsMutex.Lock()
 		delete(connectionsInitiated, peerID)
 		delete(connectionsFailed, peerID)
+		delete(connectionsTimedOut, peerID)
 		connectionsEstablished[peerID] = true
 		connectionsSuccessful[peerID] = true
 		connectionsMutex.Unlock()
+		if db != nil {
+			db.AddTransition(peerID.String(), database.StateEstablished, "")
+		}
 	}
 
-	countConnections := func() (int, int, int, int) {
+	// failed connections include the timed out ones, as in the failed_ snapshots
+	countConnections := func() (int, int, int, int, int) {
 		connectionsMutex.Lock()
 		defer connectionsMutex.Unlock()
-		return len(connectionsEstablished), len(connectionsFailed), len(connectionsInitiated), len(connectionsSuccessful)
-	}
-
-	// connect to bootstrap peers
-	bootstrapPeerInfos, err := helpers.MakePeerAddrInfoMap(bootstrapNodes)
-	if err != nil {
-		panic("Could not read list of bootstrap peers: " + err.Error())
-		return
-	}
-	go func() {
-		var wg sync.WaitGroup
-		wg.Add(len(bootstrapPeerInfos))
-		for _, peerInfo := range bootstrapPeerInfos {
-			go func(peerInfo *peer.AddrInfo) {
-				defer wg.Done()
-				setConnectionInitiated(peerInfo.ID)
-				err := ipfs.Swarm().Connect(ctx, *peerInfo)
-				if err != nil {
-					log.Printf("Could not connect to bootstrap peer %s: %s", peerInfo.ID, err)
-					setConnectionFailed(peerInfo.ID)
-				}
-			}(peerInfo)
+		failed := len(connectionsFailed)
+		for peerID := range connectionsTimedOut {
+			if !connectionsFailed[peerID] {
+				failed++
+			}
 		}
-		wg.Wait()
-	}()
+		return len(connectionsEstablished), failed, len(connectionsInitiated),
+			len(connectionsSuccessful), len(connectionsTimedOut)
+	}
 
 	// duration measurement
 	connDurations := make([]time.Duration, 0, 10)
 	connDurationsSuccess := make([]time.Duration, 0, 10)
 	connDurationsFailure := make([]time.Duration, 0, 10)
+	connDurationsTimeout := make([]time.Duration, 0, 10)
 	connDurationsMutex := &sync.Mutex{}
 	measureConnections := configValues["MeasureConnections"] != ""
 
-	// function to attempt to connect to a node and track progress
-	tryToConnect := func(peerInfo peer.AddrInfo) {
-		if !checkConnectionAndSetInitiated(peerInfo.ID) {
+	// function to attempt to connect to a node and track progress, tagging the attempt with the peer source
+	tryToConnect := func(peerInfo peer.AddrInfo, source string, applyFilters bool) {
+		if applyFilters {
+			if !peerFilter.IsAllowed(peerInfo.ID) {
+				return
+			}
+
+			// skip peers without any addresses left to dial
+			peerInfo = dialFilter.FilterAddrInfo(peerInfo)
+			if len(peerInfo.Addrs) == 0 {
+				return
+			}
+		}
+
+		if !checkConnectionAndSetInitiated(peerInfo.ID, source) {
 			return
 		}
 
@@ -244,7 +533,15 @@ func main() {
 			startTime = time.Now()
 		}
 
-		err := ipfs.Swarm().Connect(ctx, peerInfo)
+		// per-attempt deadline, if configured
+		dialCtx := ctx
+		if dialTimeout > 0 {
+			var dialCancel context.CancelFunc
+			dialCtx, dialCancel = context.WithTimeout(ctx, dialTimeout)
+			defer dialCancel()
+		}
+
+		err := ipfs.Swarm().Connect(dialCtx, peerInfo)
 
 		if measureConnections {
 			connDuration = time.Now().Sub(startTime)
@@ -259,7 +556,22 @@ func main() {
 				connDurationsSuccess = append(connDurationsSuccess, connDuration)
 				connDurationsMutex.Unlock()
 			}
+		} else if dialTimeout > 0 && dialCtx.Err() == context.DeadlineExceeded {
+			if source == "bootstrap" {
+				log.Printf("Could not connect to bootstrap peer %s: %s", peerInfo.ID, err)
+			}
+			setConnectionTimedOut(peerInfo.ID)
+
+			if measureConnections {
+				connDurationsMutex.Lock()
+				connDurations = append(connDurations, connDuration)
+				connDurationsTimeout = append(connDurationsTimeout, connDuration)
+				connDurationsMutex.Unlock()
+			}
 		} else {
+			if source == "bootstrap" {
+				log.Printf("Could not connect to bootstrap peer %s: %s", peerInfo.ID, err)
+			}
 			setConnectionFailed(peerInfo.ID)
 
 			if measureConnections {
@@ -271,78 +583,20 @@ func main() {
 		}
 	}
 
-	tryToConnectWithWg := func(wg *sync.WaitGroup, peerInfo peer.AddrInfo) {
-		tryToConnect(peerInfo)
-		wg.Done()
-	}
-
-	// slowly insert peers from DHT scan, if requested
-	if configValues["DHTPeers"] != "" {
-		go func() {
-			var wg sync.WaitGroup
-			visitedPeers, err := input.LoadVisitedPeers(configValues["DHTPeers"])
-			if err != nil {
-				log.Printf("Error loading peers from DHT scan: %s", err)
-			}
-			dhtPeers := input.VisitedPeersToAddrInfoMap(visitedPeers)
-			if dhtPeers != nil {
-				wg.Add(len(dhtPeers))
-				connsLeft := dhtConnsPerSec
-				for _, peerAddr := range dhtPeers {
-					if connsLeft < 1 {
-						time.Sleep(time.Second)
-						connsLeft = dhtConnsPerSec
-					}
-					go tryToConnectWithWg(&wg, *peerAddr)
-					connsLeft--
-				}
-			}
-			wg.Wait()
-		}()
-	}
-
-	// use ipfs-crawler to run DHT crawls if requested
-	if configValues["DHTCrawlInterval"] != "" {
-		_, weakKeysAllowed := os.LookupEnv("LIBP2P_ALLOW_WEAK_RSA_KEYS")
-		if !weakKeysAllowed {
-			log.Println("Warning: LIBP2P_ALLOW_WEAK_RSA_KEYS not set, crawling might not find most nodes.")
-		}
-
-		go func() {
-			var crawlActive sync.WaitGroup
-			for {
-				crawlActive.Add(1)
-				go func() {
-					var wg sync.WaitGroup
-					dhtPeers, err := input.CrawlDHT(configValues, helpers.PeerAddrInfoMapToSlice(bootstrapPeerInfos))
-					if err != nil {
-						panic(err)
-					}
-					if dhtPeers != nil {
-						wg.Add(len(dhtPeers))
-						connsLeft := dhtConnsPerSec
-						for _, peerAddr := range dhtPeers {
-							if connsLeft < 1 {
-								time.Sleep(time.Second)
-								connsLeft = dhtConnsPerSec
-							}
-							go tryToConnectWithWg(&wg, *peerAddr)
-							connsLeft--
-						}
-					}
-					wg.Wait()
-					crawlActive.Done()
-				}()
-
-				interval, err := time.ParseDuration(configValues["DHTCrawlInterval"])
-				if err != nil {
-					interval = time.Hour * 1
-				}
-				time.Sleep(interval)
-				crawlActive.Wait()
-			}
-		}()
+	// feed peers from bootstrap list, DHT scan file, DHT crawls, ... (see input.RegisterPeerSource)
+	peerSources, err := input.NewPeerSources(input.PeerSourceEnv{
+		ConfigValues:   configValues,
+		BootstrapPeers: bootstrapPeerInfos,
+		CrawlStatus:    crawlStatus,
+		CrawlRouting:   node.DHT.WAN,
+		CrawlHost:      node.PeerHost,
+	})
+	if err != nil {
+		panic("Could not set up peer sources: " + err.Error())
 	}
+	input.RunPeerSources(ctx, peerSources, func(peerInfo peer.AddrInfo, source input.PeerSource) {
+		tryToConnect(peerInfo, source.Name(), !input.IsUnfilteredPeerSource(source))
+	})
 
 	// collect number of connected and known peers and mean durations every 5s, try to connect to known peers
 	// write stats to log files
@@ -351,8 +605,17 @@ func main() {
 		var err error
 		if configValues["LogToStdout"] == "1" {
 			currentStat, err = stats.NewFileWithCallback(configValues["StatsFile"], func(row []float64) {
-				log.Printf("known=%d connected=%d established=%d failed=%d initiated=%d successful=%d",
-					int(row[0]), int(row[1]), int(row[2]), int(row[3]), int(row[4]), int(row[5]))
+				crawlsSuccessful, crawlsFailed := crawlStatus.Counts()
+				wantlistsWritten, wantlistsFailed, _ := wantlistStatus.Counts()
+				log.Printf("known=%d connected=%d established=%d failed=%d initiated=%d successful=%d timedout=%d "+
+					"crawls=%d crawlsFailed=%d wantlists=%d wantlistsFailed=%d wantlistsFailing=%d",
+					int(row[0]), int(row[1]), int(row[2]), int(row[3]), int(row[4]), int(row[5]), int(row[6]),
+					crawlsSuccessful, crawlsFailed, wantlistsWritten, wantlistsFailed, int(row[7]))
+				if wantlistEventLog != nil {
+					eventsWritten, eventsDropped, eventsFailed := wantlistEventLog.Counts()
+					log.Printf("wantlistEvents=%d wantlistEventsDropped=%d wantlistEventsFailed=%d",
+						eventsWritten, eventsDropped, eventsFailed)
+				}
 			})
 		} else {
 			currentStat, err = stats.NewFile(configValues["StatsFile"])
@@ -385,16 +648,26 @@ func main() {
 			if err != nil {
 				log.Printf("failed to get list of connected peers: %s", err)
 			}
-			manEstablished, manFailed, manInitiated, manSuccessful := countConnections()
+			manEstablished, manFailed, manInitiated, manSuccessful, manTimedOut := countConnections()
+			_, _, wantlistsFailing := wantlistStatus.Counts()
 			currentStat.AddInts(len(knownPeers), len(connectedPeers),
-				manEstablished, manFailed, manInitiated, manSuccessful)
+				manEstablished, manFailed, manInitiated, manSuccessful, manTimedOut, wantlistsFailing)
+			if db != nil {
+				db.AddStatsInts("peers", time.Now(), len(knownPeers), len(connectedPeers),
+					manEstablished, manFailed, manInitiated, manSuccessful, manTimedOut, wantlistsFailing)
+			}
 
 			if measureConnections {
 				connDurationsMutex.Lock()
-				durationStat.AddFloats(helpers.DurationSliceMean(connDurations, time.Millisecond),
+				durations := []float64{helpers.DurationSliceMean(connDurations, time.Millisecond),
 					helpers.DurationSliceMean(connDurationsSuccess, time.Millisecond),
-					helpers.DurationSliceMean(connDurationsFailure, time.Millisecond))
+					helpers.DurationSliceMean(connDurationsFailure, time.Millisecond),
+					helpers.DurationSliceMean(connDurationsTimeout, time.Millisecond)}
 				connDurationsMutex.Unlock()
+				durationStat.AddFloats(durations...)
+				if db != nil {
+					db.AddStats("durations", time.Now(), durations...)
+				}
 			}
 
 			for peerID, peerAddr := range knownPeers {
@@ -414,21 +687,26 @@ func main() {
 				}
 
 				if !alreadyConnected {
-					go tryToConnect(peer.AddrInfo{ID: peerID, Addrs: peerAddr})
+					go tryToConnect(peer.AddrInfo{ID: peerID, Addrs: peerAddr}, "peerstore", true)
 				}
 			}
 		}
 	}()
 
-	// write snapshots once every 10 minutes as CSV
+	// write snapshots once every 10 minutes as CSV (and/or to the database)
 	snapshotDir := configValues["Snapshots"]
-	if snapshotDir != "" {
+	if snapshotDir != "" || db != nil {
 		go func() {
-			err := helpers.CheckOrCreateDir(snapshotDir)
-			if err != nil {
-				log.Printf("Directory %s could neither be accessed nor created (error: %s), "+
-					"not writing snapshots.", snapshotDir, err.Error())
-				return
+			if snapshotDir != "" {
+				err := helpers.CheckOrCreateDir(snapshotDir)
+				if err != nil {
+					log.Printf("Directory %s could neither be accessed nor created (error: %s), "+
+						"not writing snapshots.", snapshotDir, err.Error())
+					if db == nil {
+						return
+					}
+					snapshotDir = ""
+				}
 			}
 
 			dateFormat := configValues["DateFormat"]
@@ -440,54 +718,159 @@ func main() {
 				}
 				time.Sleep(sleepDuration)
 
+				// all files of a round share the timestamp of the bundle, failures do not drop the other files
+				var bundle *helpers.SnapshotBundle
+				snapshotTime := time.Now()
+				if snapshotDir != "" {
+					bundle = helpers.NewSnapshotBundle(snapshotDir, dateFormat, snapshotCompression,
+						configValues["SnapshotShardByDay"] == "1", snapshotFormat)
+					bundle.SetDialTimeout(dialTimeout)
+					snapshotTime = bundle.Timestamp()
+				}
+				var dbSnapshot *database.Snapshot
+				if db != nil {
+					dbSnapshot = db.NewSnapshot(snapshotTime)
+				}
+				addSnapshot := func(prefix string, rows [][]string) error {
+					if dbSnapshot != nil {
+						dbSnapshot.Add(prefix, rows)
+					}
+					if bundle != nil {
+						return bundle.Add(prefix, rows)
+					}
+					return nil
+				}
+				addSnapshotFailure := func(prefix string, err error) {
+					if dbSnapshot != nil {
+						dbSnapshot.AddFailure(prefix, err)
+					}
+					if bundle != nil {
+						bundle.AddFailure(prefix, err)
+					}
+				}
+
 				knownPeers, err := ipfs.Swarm().KnownAddrs(ctx)
 				if err != nil {
 					log.Printf("failed to get list of known peers: %s", err)
-					continue
-				}
-				err = helpers.WriteToCsv("known", snapshotDir, dateFormat,
-					helpers.TransformMAMapForCsv(knownPeers))
-				if err != nil {
-					log.Printf("failed to write list of known peers to file: %s", err)
-					continue
+					addSnapshotFailure("known", err)
+				} else {
+					for peerID := range knownPeers {
+						if !peerFilter.IsAllowed(peerID) {
+							delete(knownPeers, peerID)
+						}
+					}
+					var knownPeersSlice [][]string
+					if configValues["SnapshotKnownAddrs"] == "1" {
+						knownPeersSlice = helpers.TransformMAMapForCsvWithAddrs(knownPeers, annotator)
+					} else if annotator != nil {
+						knownPeersSlice = helpers.TransformMAMapForCsvWithAnnotations(knownPeers, annotator)
+					} else {
+						knownPeersSlice = helpers.TransformMAMapForCsv(knownPeers)
+					}
+					err = addSnapshot("known", knownPeersSlice)
+					if err != nil {
+						log.Printf("failed to write list of known peers to file: %s", err)
+					}
 				}
 
 				connPeers, err := ipfs.Swarm().Peers(ctx)
 				if err != nil {
 					log.Printf("failed to get list of connected peers: %s", err)
-					continue
-				}
-				err = helpers.WriteToCsv("connected", snapshotDir, dateFormat,
-					helpers.TransformConnInfoSliceForCsv(connPeers))
-				if err != nil {
-					log.Printf("failed to write list of connected peers to file: %s", err)
-					continue
+					addSnapshotFailure("connected", err)
+				} else {
+					allowedConnPeers := connPeers[:0]
+					for _, connInfo := range connPeers {
+						if peerFilter.IsAllowed(connInfo.ID()) {
+							allowedConnPeers = append(allowedConnPeers, connInfo)
+						}
+					}
+					connPeers = allowedConnPeers
+					err = addSnapshot("connected", helpers.TransformConnInfoSliceForCsv(connPeers, node.PeerHost,
+						snapshotTime))
+					if err != nil {
+						log.Printf("failed to write list of connected peers to file: %s", err)
+					}
 				}
 
 				connectionsMutex.Lock()
-				connEstablishedSlice := helpers.TransformBoolMapForCsv(connectionsEstablished)
-				connSuccessfulSlice := helpers.TransformBoolMapForCsv(connectionsSuccessful)
-				connFailedSlice := helpers.TransformBoolMapForCsv(connectionsFailed)
+				connEstablishedSlice := helpers.TransformBoolMapForCsv(peerFilter.FilterBoolMap(connectionsEstablished))
+				connSuccessfulSlice := helpers.TransformBoolMapForCsv(peerFilter.FilterBoolMap(connectionsSuccessful))
+				// timed out peers are listed in failed_ as well, so that the analysis tools count them as failed
+				connFailedOrTimedOut := make(map[peer.ID]bool, len(connectionsFailed)+len(connectionsTimedOut))
+				for peerID := range connectionsFailed {
+					connFailedOrTimedOut[peerID] = true
+				}
+				for peerID := range connectionsTimedOut {
+					connFailedOrTimedOut[peerID] = true
+				}
+				connFailedSlice := helpers.TransformBoolMapForCsv(peerFilter.FilterBoolMap(connFailedOrTimedOut))
+				connTimedOutSlice := helpers.TransformBoolMapForCsv(peerFilter.FilterBoolMap(connectionsTimedOut))
+				connSourcesSlice := make([][]string, 0, len(connectionSources))
+				for peerID, source := range connectionSources {
+					if peerFilter.IsAllowed(peerID) {
+						connSourcesSlice = append(connSourcesSlice, []string{peerID.String(), source})
+					}
+				}
 				connectionsMutex.Unlock()
 
-				err = helpers.WriteToCsv("established", snapshotDir, dateFormat, connEstablishedSlice)
+				err = addSnapshot("established", connEstablishedSlice)
 				if err != nil {
 					log.Printf("failed to write list of established connections to file: %s", err)
-					continue
 				}
 
-				err = helpers.WriteToCsv("successful", snapshotDir, dateFormat, connSuccessfulSlice)
+				err = addSnapshot("successful", connSuccessfulSlice)
 				if err != nil {
 					log.Printf("failed to write list of successful connections to file: %s", err)
-					continue
 				}
 
-				err = helpers.WriteToCsv("failed", snapshotDir, dateFormat, connFailedSlice)
+				err = addSnapshot("failed", connFailedSlice)
 				if err != nil {
 					log.Printf("failed to write list of failed connections to file: %s", err)
+				}
+
+				if dialTimeout > 0 {
+					err = addSnapshot("timedout", connTimedOutSlice)
+					if err != nil {
+						log.Printf("failed to write list of timed out connections to file: %s", err)
+					}
+				}
+
+				err = addSnapshot("sources", connSourcesSlice)
+				if err != nil {
+					log.Printf("failed to write list of peer sources to file: %s", err)
+				}
+
+				if dialFilter.IsActive() {
+					err = addSnapshot("filtered", helpers.TransformIntMapForCsv(dialFilter.GetAndResetFiltered()))
+					if err != nil {
+						log.Printf("failed to write list of filtered addresses to file: %s", err)
+					}
+				}
+
+				if dbSnapshot != nil {
+					err = dbSnapshot.Commit()
+					if err != nil {
+						log.Printf("failed to write snapshot to database: %s", err)
+					}
+				}
+				if bundle == nil {
 					continue
 				}
 
+				err = bundle.Commit()
+				if err != nil {
+					log.Printf("failed to write snapshot bundle: %s", err)
+				}
+
+				if snapshotRetention.IsActive() {
+					deleted, err := helpers.ApplySnapshotRetention(snapshotDir, snapshotRetention, time.Now())
+					if err != nil {
+						log.Printf("failed to apply snapshot retention: %s", err)
+					}
+					if deleted > 0 {
+						log.Printf("Deleted %d snapshots (retention policy)", deleted)
+					}
+				}
 			}
 		}()
 	}
@@ -500,6 +883,11 @@ func main() {
 			for _, err := range errs {
 				log.Printf("Stats flush error: %s", err.Error())
 			}
+			if db != nil {
+				if err := db.Flush(); err != nil {
+					log.Printf("Database flush error: %s", err.Error())
+				}
+			}
 		}
 	}()
 	defer stats.FlushAndCloseAll()
@@ -0,0 +1,315 @@
+package database
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"sync"
+	"time"
+)
+
+// buckets of the database file, see README
+var (
+	bucketMeta        = []byte("meta")
+	bucketStats       = []byte("stats")
+	bucketSnapshots   = []byte("snapshots")
+	bucketTransitions = []byte("transitions")
+	bucketCrawls      = []byte("crawls")
+)
+
+// key of the failures within a snapshot bucket (snapshot types are lowercase)
+var keySnapshotFailed = []byte("_failed")
+
+// transitions kept for retrying failed flushes, the oldest ones are dropped beyond that
+var maxPendingTransitions = 100000
+
+// connection states of transitions, as tracked by ipfs-connect2all
+const (
+	StateInitiated   = "initiated"
+	StateEstablished = "established"
+	StateFailed      = "failed"
+	StateTimedOut    = "timedout"
+)
+
+// change of the connection state of a peer
+type Transition struct {
+	Time   time.Time
+	PeerID string
+	State  string
+	// peer source, only for StateInitiated
+	Source string `json:",omitempty"`
+}
+
+// row of a stats file (e.g., peersStat.dat)
+type StatsRow struct {
+	Time   time.Time
+	Values []float64
+}
+
+// result of a DHT crawl, like visitedPeers_*.json
+type Crawl struct {
+	StartTime time.Time
+	EndTime   time.Time
+	// output file of the crawler, if any
+	VisitedPeersFile string `json:",omitempty"`
+	Nodes            []CrawlNode
+}
+
+type CrawlNode struct {
+	PeerID       string
+	Addrs        []string
+	Reachable    bool
+	AgentVersion string `json:",omitempty"`
+}
+
+type SnapshotFailure struct {
+	Prefix string
+	Error  string
+}
+
+// measurement data of a run in one bbolt file; stats rows and transitions are buffered until Flush, snapshots
+// and crawls are written immediately; safe for concurrent use
+type DB struct {
+	db                 *bbolt.DB
+	mutex              *sync.Mutex
+	pendingStats       map[string][]StatsRow
+	pendingTransitions []Transition
+}
+
+// open (or create) database file for writing; the file is locked until Close
+func Open(filename string) (*DB, error) {
+	db, err := bbolt.Open(filename, 0644, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.New("Could not open database: " + err.Error())
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{bucketMeta, bucketStats, bucketSnapshots, bucketTransitions, bucketCrawls} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.New("Could not initialize database: " + err.Error())
+	}
+	return &DB{
+		db:                 db,
+		mutex:              &sync.Mutex{},
+		pendingStats:       make(map[string][]StatsRow),
+		pendingTransitions: make([]Transition, 0),
+	}, nil
+}
+
+// open database file for queries only (fails while it is opened for writing by a running instance)
+func OpenReadOnly(filename string) (*DB, error) {
+	db, err := bbolt.Open(filename, 0644, &bbolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, errors.New("Could not open database: " + err.Error())
+	}
+	return &DB{db: db, mutex: &sync.Mutex{}, pendingStats: make(map[string][]StatsRow)}, nil
+}
+
+// flush and close
+func (d *DB) Close() error {
+	var err error
+	if !d.db.IsReadOnly() {
+		err = d.Flush()
+	}
+	closeErr := d.db.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// 8 bytes (big endian Unix time in ns) for keys sorted by time
+func timeKey(t time.Time) []byte {
+	ret := make([]byte, 8)
+	binary.BigEndian.PutUint64(ret, uint64(t.UnixNano()))
+	return ret
+}
+
+func keyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:8]))).UTC()
+}
+
+// time key with sequence number for entries which may share a timestamp
+func sequenceKey(bucket *bbolt.Bucket, t time.Time) ([]byte, error) {
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]byte, 16)
+	binary.BigEndian.PutUint64(ret, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(ret[8:], seq)
+	return ret, nil
+}
+
+func putJson(bucket *bbolt.Bucket, key []byte, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
+
+// store metadata of the run (e.g., key "dialTimeout")
+func (d *DB) PutMeta(key string, value []byte) error {
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketMeta).Put([]byte(key), value)
+	})
+}
+
+// buffer stats row of the stats file name (e.g., peers), written by Flush
+func (d *DB) AddStats(name string, t time.Time, values ...float64) {
+	d.mutex.Lock()
+	d.pendingStats[name] = append(d.pendingStats[name], StatsRow{Time: t.UTC(), Values: values})
+	d.mutex.Unlock()
+}
+
+func (d *DB) AddStatsInts(name string, t time.Time, intValues ...int) {
+	values := make([]float64, len(intValues))
+	for i, v := range intValues {
+		values[i] = float64(v)
+	}
+	d.AddStats(name, t, values...)
+}
+
+// buffer connection state transition, written by Flush
+func (d *DB) AddTransition(peerID string, state string, source string) {
+	d.mutex.Lock()
+	d.pendingTransitions = append(d.pendingTransitions, Transition{
+		Time:   time.Now().UTC(),
+		PeerID: peerID,
+		State:  state,
+		Source: source,
+	})
+	d.mutex.Unlock()
+}
+
+// write buffered stats rows and transitions in one transaction; they are kept on errors (transitions up to
+// maxPendingTransitions)
+func (d *DB) Flush() error {
+	d.mutex.Lock()
+	pendingStats := d.pendingStats
+	pendingTransitions := d.pendingTransitions
+	d.pendingStats = make(map[string][]StatsRow)
+	d.pendingTransitions = make([]Transition, 0)
+	d.mutex.Unlock()
+	if len(pendingStats) == 0 && len(pendingTransitions) == 0 {
+		return nil
+	}
+
+	err := d.db.Update(func(tx *bbolt.Tx) error {
+		for name, rows := range pendingStats {
+			bucket, err := tx.Bucket(bucketStats).CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+			for _, row := range rows {
+				key, err := sequenceKey(bucket, row.Time)
+				if err != nil {
+					return err
+				}
+				if err := putJson(bucket, key, row.Values); err != nil {
+					return err
+				}
+			}
+		}
+		bucket := tx.Bucket(bucketTransitions)
+		for _, transition := range pendingTransitions {
+			key, err := sequenceKey(bucket, transition.Time)
+			if err != nil {
+				return err
+			}
+			if err := putJson(bucket, key, transition); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// retry with the next flush
+		d.mutex.Lock()
+		for name, rows := range pendingStats {
+			d.pendingStats[name] = append(rows, d.pendingStats[name]...)
+		}
+		d.pendingTransitions = append(pendingTransitions, d.pendingTransitions...)
+		dropped := len(d.pendingTransitions) - maxPendingTransitions
+		if dropped > 0 {
+			d.pendingTransitions = append(make([]Transition, 0, maxPendingTransitions),
+				d.pendingTransitions[dropped:]...)
+		}
+		d.mutex.Unlock()
+		if dropped > 0 {
+			return fmt.Errorf("Could not write to database, dropped %d transitions: %s", dropped, err.Error())
+		}
+		return errors.New("Could not write to database: " + err.Error())
+	}
+	return nil
+}
+
+// store result of a DHT crawl
+func (d *DB) AddCrawl(crawl *Crawl) error {
+	err := d.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketCrawls)
+		key, err := sequenceKey(bucket, crawl.StartTime)
+		if err != nil {
+			return err
+		}
+		return putJson(bucket, key, crawl)
+	})
+	if err != nil {
+		return errors.New("Could not write crawl to database: " + err.Error())
+	}
+	return nil
+}
+
+// peer lists of one snapshot round, written in one transaction by Commit (like helpers.SnapshotBundle)
+type Snapshot struct {
+	db        *DB
+	timestamp time.Time
+	rows      map[string][][]string
+	failed    []SnapshotFailure
+}
+
+func (d *DB) NewSnapshot(timestamp time.Time) *Snapshot {
+	return &Snapshot{
+		db:        d,
+		timestamp: timestamp.UTC(),
+		rows:      make(map[string][][]string),
+		failed:    make([]SnapshotFailure, 0),
+	}
+}
+
+// add rows of a snapshot type (e.g., known), as written to the snapshot files
+func (s *Snapshot) Add(prefix string, rows [][]string) {
+	s.rows[prefix] = rows
+}
+
+func (s *Snapshot) AddFailure(prefix string, err error) {
+	s.failed = append(s.failed, SnapshotFailure{Prefix: prefix, Error: err.Error()})
+}
+
+func (s *Snapshot) Commit() error {
+	err := s.db.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.Bucket(bucketSnapshots).CreateBucketIfNotExists(timeKey(s.timestamp))
+		if err != nil {
+			return err
+		}
+		for prefix, rows := range s.rows {
+			if err := putJson(bucket, []byte(prefix), rows); err != nil {
+				return err
+			}
+		}
+		return putJson(bucket, keySnapshotFailed, s.failed)
+	})
+	if err != nil {
+		return errors.New("Could not write snapshot to database: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"errors"
+	"go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T) (*DB, string, func()) {
+	dir, err := ioutil.TempDir("", "database")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "test.db")
+	db, err := Open(filename)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, filename, func() {
+		_ = db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestStatsOrderAndRange(t *testing.T) {
+	db, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	t0 := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	t2 := t1.Add(time.Minute)
+	// rows sharing a timestamp keep the order in which they were added (sequence keys)
+	db.AddStatsInts("peers", t1, 1)
+	db.AddStatsInts("peers", t0, 0)
+	db.AddStatsInts("peers", t1, 2)
+	db.AddStatsInts("peers", t2, 3)
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		from     time.Time
+		to       time.Time
+		expected []float64
+	}{
+		{time.Time{}, time.Time{}, []float64{0, 1, 2, 3}},
+		{t1, t1, []float64{1, 2}},
+		{time.Time{}, t1, []float64{0, 1, 2}},
+		{t1.Add(time.Nanosecond), time.Time{}, []float64{3}},
+		{t2.Add(time.Second), time.Time{}, []float64{}},
+	} {
+		rows, err := db.Stats("peers", test.from, test.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := make([]float64, len(rows))
+		for i, row := range rows {
+			values[i] = row.Values[0]
+		}
+		if len(values) != len(test.expected) {
+			t.Fatalf("[%s, %s]: got %v, expected %v", test.from, test.to, values, test.expected)
+		}
+		for i := range values {
+			if values[i] != test.expected[i] {
+				t.Fatalf("[%s, %s]: got %v, expected %v", test.from, test.to, values, test.expected)
+			}
+		}
+	}
+	if rows, _ := db.Stats("peers", t0, t0); len(rows) != 1 || !rows[0].Time.Equal(t0) {
+		t.Errorf("unexpected row time: %+v", rows)
+	}
+	if rows, err := db.Stats("durations", time.Time{}, time.Time{}); err != nil || len(rows) != 0 {
+		t.Errorf("unexpected rows of missing stats: %+v, %v", rows, err)
+	}
+}
+
+func TestFlushRetry(t *testing.T) {
+	db, filename, cleanup := openTestDB(t)
+	defer cleanup()
+
+	// writes fail while the file is closed, the buffered transitions are kept up to maxPendingTransitions
+	defer func(max int) { maxPendingTransitions = max }(maxPendingTransitions)
+	maxPendingTransitions = 2
+	if err := db.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db.AddTransition("peerA", StateInitiated, "bootstrap")
+	db.AddStatsInts("peers", time.Now(), 1)
+	if err := db.Flush(); err == nil {
+		t.Fatal("flush to closed database succeeded")
+	}
+	db.AddTransition("peerA", StateEstablished, "")
+	db.AddTransition("peerB", StateInitiated, "dht")
+	if err := db.Flush(); err == nil {
+		t.Fatal("flush to closed database succeeded")
+	}
+	if len(db.pendingTransitions) != 2 {
+		t.Fatalf("%d pending transitions, expected 2", len(db.pendingTransitions))
+	}
+
+	boltDB, err := bbolt.Open(filename, 0644, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.db = boltDB
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	transitions, err := db.Transitions(time.Time{}, time.Time{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the oldest transition has been dropped
+	if len(transitions) != 2 || transitions[0].PeerID != "peerA" || transitions[0].State != StateEstablished ||
+		transitions[1].PeerID != "peerB" || transitions[1].Source != "dht" {
+		t.Fatalf("unexpected transitions: %+v", transitions)
+	}
+	if transitions, _ := db.Transitions(time.Time{}, time.Time{}, "peerB"); len(transitions) != 1 {
+		t.Errorf("unexpected transitions of peerB: %+v", transitions)
+	}
+	if rows, _ := db.Stats("peers", time.Time{}, time.Time{}); len(rows) != 1 {
+		t.Errorf("unexpected stats rows: %+v", rows)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	db, _, cleanup := openTestDB(t)
+	defer cleanup()
+
+	timestamp := time.Date(2021, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+	known := [][]string{{"peerA", "DE", "3320"}, {"peerB", "", ""}}
+	connected := [][]string{{"peerA", "2", "/ipfs/kad/1.0.0"}}
+	snapshot := db.NewSnapshot(timestamp)
+	snapshot.Add("known", known)
+	snapshot.Add("connected", connected)
+	snapshot.AddFailure("failed", errors.New("test error"))
+	if err := snapshot.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.NewSnapshot(timestamp.Add(time.Hour)).Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	timestamps, err := db.SnapshotTimestamps("connected", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timestamps) != 1 || !timestamps[0].Equal(timestamp) {
+		t.Fatalf("unexpected snapshot timestamps: %v", timestamps)
+	}
+	if timestamps, _ := db.SnapshotTimestamps("", timestamp.Add(time.Second), time.Time{}); len(timestamps) != 1 {
+		t.Errorf("unexpected snapshot timestamps: %v", timestamps)
+	}
+	if next, _ := db.NextSnapshot(timestamp.Add(-time.Minute)); !next.Equal(timestamp) {
+		t.Errorf("unexpected next snapshot: %s", next)
+	}
+
+	rows, failed, err := db.Snapshot(timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || len(rows["known"]) != 2 || rows["known"][0][1] != "DE" || rows["known"][1][0] != "peerB" ||
+		len(rows["connected"]) != 1 || rows["connected"][0][2] != "/ipfs/kad/1.0.0" {
+		t.Errorf("unexpected snapshot rows: %v", rows)
+	}
+	if len(failed) != 1 || failed[0].Prefix != "failed" || failed[0].Error != "test error" {
+		t.Errorf("unexpected snapshot failures: %+v", failed)
+	}
+	rows, _, err = db.Snapshot(timestamp, "connected", "successful")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || len(rows["connected"]) != 1 {
+		t.Errorf("unexpected snapshot rows: %v", rows)
+	}
+	if _, _, err := db.Snapshot(timestamp.Add(time.Minute)); err == nil {
+		t.Error("missing snapshot returned without error")
+	}
+}
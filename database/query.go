@@ -0,0 +1,218 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+// iterate over the entries of a bucket with time keys in [from, to], zero times are unbounded
+func forEachInRange(bucket *bbolt.Bucket, from time.Time, to time.Time, f func(key []byte, value []byte) error) error {
+	if bucket == nil {
+		return nil
+	}
+	c := bucket.Cursor()
+	var k, v []byte
+	if from.IsZero() {
+		k, v = c.First()
+	} else {
+		k, v = c.Seek(timeKey(from))
+	}
+	var toKey []byte
+	if !to.IsZero() {
+		toKey = timeKey(to)
+	}
+	for ; k != nil; k, v = c.Next() {
+		if len(k) < 8 {
+			continue
+		}
+		if toKey != nil && bytes.Compare(k[:8], toKey) > 0 {
+			break
+		}
+		if err := f(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// names of the stored stats (e.g., peers)
+func (d *DB) StatsNames() ([]string, error) {
+	ret := make([]string, 0)
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketStats).ForEach(func(k, v []byte) error {
+			ret = append(ret, string(k))
+			return nil
+		})
+	})
+	return ret, err
+}
+
+// stats rows of name in [from, to] (zero: unbounded), sorted by time
+func (d *DB) Stats(name string, from time.Time, to time.Time) ([]StatsRow, error) {
+	ret := make([]StatsRow, 0)
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		return forEachInRange(tx.Bucket(bucketStats).Bucket([]byte(name)), from, to, func(k, v []byte) error {
+			row := StatsRow{Time: keyTime(k)}
+			if err := json.Unmarshal(v, &row.Values); err != nil {
+				return err
+			}
+			ret = append(ret, row)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.New("Could not read stats from database: " + err.Error())
+	}
+	return ret, nil
+}
+
+// transitions in [from, to] (zero: unbounded), only of peerID if not empty, sorted by time
+func (d *DB) Transitions(from time.Time, to time.Time, peerID string) ([]Transition, error) {
+	ret := make([]Transition, 0)
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		return forEachInRange(tx.Bucket(bucketTransitions), from, to, func(k, v []byte) error {
+			var transition Transition
+			if err := json.Unmarshal(v, &transition); err != nil {
+				return err
+			}
+			if peerID == "" || transition.PeerID == peerID {
+				ret = append(ret, transition)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.New("Could not read transitions from database: " + err.Error())
+	}
+	return ret, nil
+}
+
+// crawls started in [from, to] (zero: unbounded), sorted by start time
+func (d *DB) Crawls(from time.Time, to time.Time) ([]*Crawl, error) {
+	ret := make([]*Crawl, 0)
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		return forEachInRange(tx.Bucket(bucketCrawls), from, to, func(k, v []byte) error {
+			var crawl Crawl
+			if err := json.Unmarshal(v, &crawl); err != nil {
+				return err
+			}
+			ret = append(ret, &crawl)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.New("Could not read crawls from database: " + err.Error())
+	}
+	return ret, nil
+}
+
+// first crawl started at or after timestamp, nil if there is none
+func (d *DB) NextCrawl(timestamp time.Time) (*Crawl, error) {
+	var ret *Crawl
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		k, v := tx.Bucket(bucketCrawls).Cursor().Seek(timeKey(timestamp))
+		if k == nil {
+			return nil
+		}
+		ret = &Crawl{}
+		return json.Unmarshal(v, ret)
+	})
+	if err != nil {
+		return nil, errors.New("Could not read crawl from database: " + err.Error())
+	}
+	return ret, nil
+}
+
+// timestamps of the snapshots in [from, to] (zero: unbounded) containing the snapshot type prefix (e.g.,
+// connected; empty: all snapshots), sorted
+func (d *DB) SnapshotTimestamps(prefix string, from time.Time, to time.Time) ([]time.Time, error) {
+	ret := make([]time.Time, 0)
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		snapshots := tx.Bucket(bucketSnapshots)
+		return forEachInRange(snapshots, from, to, func(k, v []byte) error {
+			if prefix == "" || snapshots.Bucket(k).Get([]byte(prefix)) != nil {
+				ret = append(ret, keyTime(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.New("Could not read snapshots from database: " + err.Error())
+	}
+	return ret, nil
+}
+
+// timestamp of the first snapshot at or after timestamp, zero if there is none
+func (d *DB) NextSnapshot(timestamp time.Time) (time.Time, error) {
+	var ret time.Time
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		k, _ := tx.Bucket(bucketSnapshots).Cursor().Seek(timeKey(timestamp))
+		if k != nil {
+			ret = keyTime(k)
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// rows of the snapshot types prefixes (all if none are given) of the snapshot taken at timestamp (see
+// SnapshotTimestamps), and the failures of the snapshot
+func (d *DB) Snapshot(timestamp time.Time, prefixes ...string) (map[string][][]string, []SnapshotFailure, error) {
+	rows := make(map[string][][]string)
+	failed := make([]SnapshotFailure, 0)
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		snapshot := tx.Bucket(bucketSnapshots).Bucket(timeKey(timestamp))
+		if snapshot == nil {
+			return errors.New("no snapshot at " + timestamp.String())
+		}
+		if v := snapshot.Get(keySnapshotFailed); v != nil {
+			if err := json.Unmarshal(v, &failed); err != nil {
+				return err
+			}
+		}
+		if len(prefixes) == 0 {
+			return snapshot.ForEach(func(k, v []byte) error {
+				if bytes.Equal(k, keySnapshotFailed) {
+					return nil
+				}
+				var prefixRows [][]string
+				if err := json.Unmarshal(v, &prefixRows); err != nil {
+					return err
+				}
+				rows[string(k)] = prefixRows
+				return nil
+			})
+		}
+		for _, prefix := range prefixes {
+			v := snapshot.Get([]byte(prefix))
+			if v == nil {
+				continue
+			}
+			var prefixRows [][]string
+			if err := json.Unmarshal(v, &prefixRows); err != nil {
+				return err
+			}
+			rows[prefix] = prefixRows
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.New("Could not read snapshot from database: " + err.Error())
+	}
+	return rows, failed, nil
+}
+
+// metadata stored with PutMeta, nil if not set
+func (d *DB) GetMeta(key string) ([]byte, error) {
+	var ret []byte
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		if v := tx.Bucket(bucketMeta).Get([]byte(key)); v != nil {
+			ret = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return ret, err
+}
@@ -6,9 +6,14 @@ require (
 	github.com/ipfs/go-ipfs v0.8.0
 	github.com/ipfs/go-ipfs-config v0.9.0
 	github.com/ipfs/interface-go-ipfs-core v0.4.0
+	github.com/klauspost/compress v1.11.7
+	github.com/libp2p/go-libp2p v0.11.0
 	github.com/libp2p/go-libp2p-core v0.6.1
+	github.com/libp2p/go-libp2p-kad-dht v0.10.0
 	github.com/multiformats/go-multiaddr v0.3.1
+	github.com/oschwald/maxminddb-golang v1.8.0
 	github.com/prometheus/common v0.10.0
+	go.etcd.io/bbolt v1.3.5
 	ipfs-crawler v0.0.0 //-20200603141538-ec2c9372e689
 )
 
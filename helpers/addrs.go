@@ -0,0 +1,105 @@
+package helpers
+
+import (
+	"errors"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"strconv"
+	"strings"
+)
+
+// properties of the addresses of a peer, see NewAddrSummary
+type AddrSummary struct {
+	NumAddrs int
+	IPv4     bool
+	IPv6     bool
+	TCP      bool
+	QUIC     bool
+	// at least one address outside of the private, loopback and link-local ranges (DNS addresses count as public)
+	Public bool
+	Addrs  []multiaddr.Multiaddr
+}
+
+func NewAddrSummary(addrs []multiaddr.Multiaddr) AddrSummary {
+	ret := AddrSummary{NumAddrs: len(addrs), Addrs: addrs}
+	for _, addr := range addrs {
+		isCircuit := false
+		for _, p := range addr.Protocols() {
+			switch p.Code {
+			case multiaddr.P_CIRCUIT:
+				isCircuit = true
+			case multiaddr.P_TCP:
+				ret.TCP = true
+			case multiaddr.P_QUIC:
+				ret.QUIC = true
+			}
+		}
+		// the address of a relay says nothing about the peer itself
+		if isCircuit {
+			continue
+		}
+		if _, err := addr.ValueForProtocol(multiaddr.P_IP4); err == nil {
+			ret.IPv4 = true
+		} else if _, err := addr.ValueForProtocol(multiaddr.P_DNS4); err == nil {
+			ret.IPv4 = true
+			ret.Public = true
+		}
+		if _, err := addr.ValueForProtocol(multiaddr.P_IP6); err == nil {
+			ret.IPv6 = true
+		} else if _, err := addr.ValueForProtocol(multiaddr.P_DNS6); err == nil {
+			ret.IPv6 = true
+			ret.Public = true
+		}
+		if ip, err := manet.ToIP(addr); err == nil && !IsUnroutableIP(ip) && !ip.IsMulticast() {
+			ret.Public = true
+		}
+	}
+	return ret
+}
+
+func boolColumn(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+// snapshot columns: number of addresses, IPv4, IPv6, TCP, QUIC, public (0 or 1), addresses (comma-separated)
+func (s AddrSummary) CsvColumns() []string {
+	addrStrings := make([]string, len(s.Addrs))
+	for i, addr := range s.Addrs {
+		addrStrings[i] = addr.String()
+	}
+	return []string{strconv.Itoa(s.NumAddrs), boolColumn(s.IPv4), boolColumn(s.IPv6), boolColumn(s.TCP),
+		boolColumn(s.QUIC), boolColumn(s.Public), strings.Join(addrStrings, ",")}
+}
+
+// parse snapshot columns written by CsvColumns, addresses which cannot be parsed are skipped
+func AddrSummaryFromCsvColumns(columns []string) (AddrSummary, error) {
+	if len(columns) < 7 {
+		return AddrSummary{}, errors.New("Address summary needs seven columns")
+	}
+	numAddrs, err := strconv.Atoi(columns[0])
+	if err != nil {
+		return AddrSummary{}, errors.New("Could not parse number of addresses: " + err.Error())
+	}
+	ret := AddrSummary{
+		NumAddrs: numAddrs,
+		IPv4:     columns[1] == "1",
+		IPv6:     columns[2] == "1",
+		TCP:      columns[3] == "1",
+		QUIC:     columns[4] == "1",
+		Public:   columns[5] == "1",
+		Addrs:    make([]multiaddr.Multiaddr, 0, numAddrs),
+	}
+	if columns[6] != "" {
+		for _, addrString := range strings.Split(columns[6], ",") {
+			addr, err := multiaddr.NewMultiaddr(addrString)
+			if err != nil {
+				continue
+			}
+			ret.Addrs = append(ret.Addrs, addr)
+		}
+	}
+	return ret, nil
+}
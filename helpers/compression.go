@@ -0,0 +1,100 @@
+package helpers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// check compression name
+func CheckCompression(compression string) error {
+	switch compression {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return errors.New("Unknown compression: " + compression)
+}
+
+// file extension appended to compressed files
+func CompressionExtension(compression string) string {
+	switch compression {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// compressing writer, closing it does not close w
+func NewCompressedWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	}
+	return nil, errors.New("Unknown compression: " + compression)
+}
+
+type decompressedFile struct {
+	io.Reader
+	decompressor io.Closer
+	file         *os.File
+}
+
+func (f *decompressedFile) Close() error {
+	if f.decompressor != nil {
+		_ = f.decompressor.Close()
+	}
+	return f.file.Close()
+}
+
+// open file for reading, gzip- and zstd-compressed files are detected by their magic bytes and decompressed
+func OpenDecompressed(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	bufferedReader := bufio.NewReader(f)
+	ret := &decompressedFile{Reader: bufferedReader, file: f}
+	magic, _ := bufferedReader.Peek(4)
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		gzipReader, err := gzip.NewReader(bufferedReader)
+		if err != nil {
+			f.Close()
+			return nil, errors.New("Could not decompress file: " + err.Error())
+		}
+		ret.Reader = gzipReader
+		ret.decompressor = gzipReader
+	case len(magic) == 4 && magic[0] == 0x28 && magic[1] == 0xb5 && magic[2] == 0x2f && magic[3] == 0xfd:
+		zstdReader, err := zstd.NewReader(bufferedReader)
+		if err != nil {
+			f.Close()
+			return nil, errors.New("Could not decompress file: " + err.Error())
+		}
+		ret.Reader = zstdReader
+		ret.decompressor = zstdReader.IOReadCloser()
+	}
+	return ret, nil
+}
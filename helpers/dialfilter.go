@@ -0,0 +1,235 @@
+package helpers
+
+import (
+	"bufio"
+	"errors"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// private, loopback and link-local ranges which are not routable in the public internet
+var unroutableCIDRs = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"100::/64",
+	"2001:2::/48",
+	"2001:db8::/32",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+var unroutableNets = mustParseCIDRs(unroutableCIDRs)
+
+type DialFilter struct {
+	SkipPrivate bool
+	DropCircuit bool
+	Blocklist   []*net.IPNet
+	Allowlist   []*net.IPNet
+	Transports  map[string]bool
+
+	filteredMutex *sync.Mutex
+	// distinct filtered addresses per peer since the last GetAndResetFiltered
+	filtered map[peer.ID]map[string]bool
+}
+
+func NewDialFilter() *DialFilter {
+	return &DialFilter{
+		filteredMutex: &sync.Mutex{},
+		filtered:      make(map[peer.ID]map[string]bool),
+	}
+}
+
+// returns true if at least one filter is configured
+func (f *DialFilter) IsActive() bool {
+	return f.SkipPrivate || f.DropCircuit || len(f.Blocklist) > 0 || len(f.Allowlist) > 0 || len(f.Transports) > 0
+}
+
+// returns true if the address may be dialed
+func (f *DialFilter) AllowAddr(addr multiaddr.Multiaddr) bool {
+	transport := GetTransport(addr)
+	if f.DropCircuit && transport == "p2p-circuit" {
+		return false
+	}
+	if len(f.Transports) > 0 && !f.Transports[transport] {
+		return false
+	}
+
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		// not an IP address (e.g., DNS or circuit), cannot be checked against ranges
+		return len(f.Allowlist) == 0
+	}
+	if f.SkipPrivate && IsUnroutableIP(ip) {
+		return false
+	}
+	if ipInNets(ip, f.Blocklist) {
+		return false
+	}
+	if len(f.Allowlist) > 0 && !ipInNets(ip, f.Allowlist) {
+		return false
+	}
+	return true
+}
+
+// remove addresses which may not be dialed and count them for the peer; addresses with a DNS name are kept, the
+// resolved addresses are checked by the DialGater
+func (f *DialFilter) FilterAddrInfo(peerInfo peer.AddrInfo) peer.AddrInfo {
+	if !f.IsActive() {
+		return peerInfo
+	}
+	allowed := make([]multiaddr.Multiaddr, 0, len(peerInfo.Addrs))
+	for _, addr := range peerInfo.Addrs {
+		if IsDNSAddr(addr) || f.AllowAddr(addr) {
+			allowed = append(allowed, addr)
+		} else {
+			f.RecordFiltered(peerInfo.ID, addr)
+		}
+	}
+	return peer.AddrInfo{ID: peerInfo.ID, Addrs: allowed}
+}
+
+// count a filtered address of the peer (each address once until the next GetAndResetFiltered)
+func (f *DialFilter) RecordFiltered(peerID peer.ID, addr multiaddr.Multiaddr) {
+	f.filteredMutex.Lock()
+	defer f.filteredMutex.Unlock()
+	if f.filtered[peerID] == nil {
+		f.filtered[peerID] = make(map[string]bool)
+	}
+	f.filtered[peerID][addr.String()] = true
+}
+
+// get number of distinct filtered addresses per peer since the last call
+func (f *DialFilter) GetAndResetFiltered() map[peer.ID]int {
+	f.filteredMutex.Lock()
+	filtered := f.filtered
+	f.filtered = make(map[peer.ID]map[string]bool)
+	f.filteredMutex.Unlock()
+	ret := make(map[peer.ID]int, len(filtered))
+	for peerID, addrs := range filtered {
+		ret[peerID] = len(addrs)
+	}
+	return ret
+}
+
+// returns true if the address starts with a DNS name (/dns, /dns4, /dns6, /dnsaddr), i.e., its IP address is only
+// known after resolving it
+func IsDNSAddr(addr multiaddr.Multiaddr) bool {
+	protocols := addr.Protocols()
+	if len(protocols) == 0 {
+		return false
+	}
+	switch protocols[0].Code {
+	case multiaddr.P_DNS, multiaddr.P_DNS4, multiaddr.P_DNS6, multiaddr.P_DNSADDR:
+		return true
+	}
+	return false
+}
+
+// get transport of a multiaddr (p2p-circuit, quic, wss, ws, tcp, udp or the first protocol's name)
+func GetTransport(addr multiaddr.Multiaddr) string {
+	protocols := addr.Protocols()
+	ret := ""
+	for _, p := range protocols {
+		switch p.Code {
+		case multiaddr.P_CIRCUIT:
+			return "p2p-circuit"
+		case multiaddr.P_QUIC:
+			ret = "quic"
+		case multiaddr.P_WSS:
+			ret = "wss"
+		case multiaddr.P_WS:
+			if ret != "wss" {
+				ret = "ws"
+			}
+		case multiaddr.P_TCP, multiaddr.P_UDP:
+			if ret == "" {
+				ret = p.Name
+			}
+		}
+	}
+	if ret == "" && len(protocols) > 0 {
+		ret = protocols[0].Name
+	}
+	return ret
+}
+
+func IsUnroutableIP(ip net.IP) bool {
+	return ipInNets(ip, unroutableNets)
+}
+
+// load CIDR list file, one range (or single IP address) per line, # starts a comment
+func LoadCIDRList(cidrListFile string) ([]*net.IPNet, error) {
+	f, err := os.Open(cidrListFile)
+	if err != nil {
+		return nil, errors.New("Could not open CIDR list file for reading: " + err.Error())
+	}
+	defer f.Close()
+
+	scn := bufio.NewScanner(f)
+	ret := make([]*net.IPNet, 0)
+	for scn.Scan() {
+		st := scn.Text()
+		if commentPos := strings.IndexByte(st, '#'); commentPos >= 0 {
+			st = st[:commentPos]
+		}
+		st = strings.TrimSpace(st)
+		if len(st) < 1 {
+			continue
+		}
+		if strings.IndexByte(st, '/') < 0 {
+			if strings.IndexByte(st, ':') < 0 {
+				st += "/32"
+			} else {
+				st += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(st)
+		if err != nil {
+			return nil, errors.New("Could not parse CIDR list file: " + err.Error())
+		}
+		ret = append(ret, ipNet)
+	}
+	if err := scn.Err(); err != nil {
+		return nil, errors.New("Could not read CIDR list file: " + err.Error())
+	}
+	return ret, nil
+}
+
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDRs(cidrs []string) []*net.IPNet {
+	ret := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		ret[i] = ipNet
+	}
+	return ret
+}
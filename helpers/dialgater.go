@@ -0,0 +1,81 @@
+package helpers
+
+import (
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/control"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	p2pconfig "github.com/libp2p/go-libp2p/config"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// peers which may not be connected at all, see input.PeerFilter
+type PeerDenylist interface {
+	IsDenied(peerID peer.ID) bool
+}
+
+// connection gater enforcing the dial filter for all dials of the node (including addresses from the peerstore
+// and dials by the DHT, bitswap, ...), not only for the AddrInfos passed to Connect, and the peer denylist for all
+// connections (also inbound ones); everything else is decided by the gater configured before (e.g., the address
+// filters of go-ipfs)
+type DialGater struct {
+	filter   *DialFilter
+	denylist PeerDenylist
+	// peers which are neither filtered nor denied (e.g., bootstrap peers with UnfilteredBootstrap)
+	unfiltered map[peer.ID]bool
+	next       connmgr.ConnectionGater
+}
+
+// denylist and unfilteredPeers may be nil
+func NewDialGater(filter *DialFilter, denylist PeerDenylist, unfilteredPeers map[peer.ID]bool) *DialGater {
+	return &DialGater{filter: filter, denylist: denylist, unfiltered: unfilteredPeers}
+}
+
+func (g *DialGater) isDenied(p peer.ID) bool {
+	return g.denylist != nil && !g.unfiltered[p] && g.denylist.IsDenied(p)
+}
+
+// libp2p option installing the gater in front of the gater configured by the previous options
+func (g *DialGater) Option() libp2p.Option {
+	return func(cfg *p2pconfig.Config) error {
+		g.next = cfg.ConnectionGater
+		cfg.ConnectionGater = g
+		return nil
+	}
+}
+
+func (g *DialGater) InterceptPeerDial(p peer.ID) bool {
+	if g.isDenied(p) {
+		return false
+	}
+	return g.next == nil || g.next.InterceptPeerDial(p)
+}
+
+// called with the resolved addresses (DNS names are resolved by the host before dialing)
+func (g *DialGater) InterceptAddrDial(p peer.ID, addr multiaddr.Multiaddr) bool {
+	if !g.unfiltered[p] && !g.filter.AllowAddr(addr) {
+		g.filter.RecordFiltered(p, addr)
+		return false
+	}
+	return g.next == nil || g.next.InterceptAddrDial(p, addr)
+}
+
+func (g *DialGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return g.next == nil || g.next.InterceptAccept(addrs)
+}
+
+// the peer ID of inbound connections is only known after the security handshake
+func (g *DialGater) InterceptSecured(dir network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	if g.isDenied(p) {
+		return false
+	}
+	return g.next == nil || g.next.InterceptSecured(dir, p, addrs)
+}
+
+func (g *DialGater) InterceptUpgraded(conn network.Conn) (bool, control.DisconnectReason) {
+	if g.next == nil {
+		return true, 0
+	}
+	return g.next.InterceptUpgraded(conn)
+}
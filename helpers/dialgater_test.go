@@ -0,0 +1,174 @@
+package helpers
+
+import (
+	"context"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+	"testing"
+	"time"
+)
+
+func newLoopbackHost(t *testing.T, ctx context.Context) host.Host {
+	h, err := libp2p.New(ctx, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// dial only with addresses from the peerstore (as after identify or a DHT lookup), not from the AddrInfo
+func connectFromPeerstore(ctx context.Context, dialer host.Host, target host.Host) error {
+	dialer.Peerstore().AddAddrs(target.ID(), target.Addrs(), peerstore.PermanentAddrTTL)
+	dialCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return dialer.Connect(dialCtx, peer.AddrInfo{ID: target.ID()})
+}
+
+func TestDialGaterBlocksPeerstoreAddrs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	filter := NewDialFilter()
+	filter.SkipPrivate = true
+	// address filters before the gater, as configured by go-ipfs
+	dialer, err := libp2p.New(ctx, libp2p.NoListenAddrs, libp2p.Filters(multiaddr.NewFilters()),
+		NewDialGater(filter, nil, nil).Option())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+	target := newLoopbackHost(t, ctx)
+	defer target.Close()
+
+	if err := connectFromPeerstore(ctx, dialer, target); err == nil {
+		t.Fatal("connected to a filtered loopback address")
+	}
+	if conns := target.Network().ConnsToPeer(dialer.ID()); len(conns) != 0 {
+		t.Fatalf("filtered address has been dialed (%d connections)", len(conns))
+	}
+	if filtered := filter.GetAndResetFiltered(); filtered[target.ID()] != len(target.Addrs()) {
+		t.Errorf("%d filtered addresses counted, expected %d", filtered[target.ID()], len(target.Addrs()))
+	}
+}
+
+func TestDialGaterAllowsUnfilteredAddrs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	filter := NewDialFilter()
+	filter.DropCircuit = true
+	dialer, err := libp2p.New(ctx, libp2p.NoListenAddrs, libp2p.Filters(multiaddr.NewFilters()),
+		NewDialGater(filter, nil, nil).Option())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+	target := newLoopbackHost(t, ctx)
+	defer target.Close()
+
+	if err := connectFromPeerstore(ctx, dialer, target); err != nil {
+		t.Fatalf("could not connect to an unfiltered address: %s", err)
+	}
+}
+
+func TestDialGaterUnfilteredPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	target := newLoopbackHost(t, ctx)
+	defer target.Close()
+	filter := NewDialFilter()
+	filter.SkipPrivate = true
+	dialer, err := libp2p.New(ctx, libp2p.NoListenAddrs, libp2p.Filters(multiaddr.NewFilters()),
+		NewDialGater(filter, nil, map[peer.ID]bool{target.ID(): true}).Option())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	if err := connectFromPeerstore(ctx, dialer, target); err != nil {
+		t.Fatalf("could not connect to an unfiltered peer: %s", err)
+	}
+}
+
+func TestDialFilterAllowAddr(t *testing.T) {
+	filter := NewDialFilter()
+	filter.SkipPrivate = true
+	filter.DropCircuit = true
+	filter.Transports = map[string]bool{"tcp": true, "p2p-circuit": true}
+	cases := map[string]bool{
+		"/ip4/8.8.8.8/tcp/4001":              true,
+		"/ip4/192.168.1.1/tcp/4001":          false,
+		"/ip4/8.8.8.8/udp/4001/quic":         false,
+		"/ip4/8.8.8.8/tcp/4001/p2p-circuit":  false,
+		"/ip6/fe80::1/tcp/4001":              false,
+		"/ip6/2001:4860:4860::8888/tcp/4001": true,
+	}
+	for addr, allowed := range cases {
+		if filter.AllowAddr(multiaddr.StringCast(addr)) != allowed {
+			t.Errorf("AllowAddr(%s) != %t", addr, allowed)
+		}
+	}
+}
+
+func TestDialFilterKeepsDNSAddrs(t *testing.T) {
+	filter := NewDialFilter()
+	filter.Allowlist = mustParseCIDRs([]string{"8.8.8.0/24"})
+	filter.Transports = map[string]bool{"tcp": true}
+	peerID, err := peer.Decode("QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerInfo := filter.FilterAddrInfo(peer.AddrInfo{ID: peerID, Addrs: []multiaddr.Multiaddr{
+		multiaddr.StringCast("/dnsaddr/bootstrap.libp2p.io"),
+		multiaddr.StringCast("/dns4/example.com/tcp/4001"),
+		multiaddr.StringCast("/ip4/8.8.8.8/tcp/4001"),
+		multiaddr.StringCast("/ip4/1.1.1.1/tcp/4001"),
+	}})
+	if len(peerInfo.Addrs) != 3 {
+		t.Errorf("%d addresses left, expected 3: %v", len(peerInfo.Addrs), peerInfo.Addrs)
+	}
+	if filtered := filter.GetAndResetFiltered(); filtered[peerID] != 1 {
+		t.Errorf("%d filtered addresses counted, expected 1", filtered[peerID])
+	}
+	// resolved addresses are checked by the gater
+	if filter.AllowAddr(multiaddr.StringCast("/dns4/example.com/tcp/4001")) {
+		t.Error("address with DNS name allowed by the allowlist")
+	}
+}
+
+type testDenylist map[peer.ID]bool
+
+func (d testDenylist) IsDenied(peerID peer.ID) bool {
+	return d[peerID]
+}
+
+func TestDialGaterDenylist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	target := newLoopbackHost(t, ctx)
+	defer target.Close()
+	inbound := newLoopbackHost(t, ctx)
+	defer inbound.Close()
+	gated, err := libp2p.New(ctx, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		libp2p.Filters(multiaddr.NewFilters()),
+		NewDialGater(NewDialFilter(), testDenylist{target.ID(): true, inbound.ID(): true}, nil).Option())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gated.Close()
+
+	if err := connectFromPeerstore(ctx, gated, target); err == nil {
+		t.Error("connected to a denied peer")
+	}
+	// inbound connection from a denied peer
+	_ = connectFromPeerstore(ctx, inbound, gated)
+	time.Sleep(100 * time.Millisecond)
+	if conns := gated.Network().ConnsToPeer(inbound.ID()); len(conns) != 0 {
+		t.Errorf("inbound connection from a denied peer accepted (%d connections)", len(conns))
+	}
+}
@@ -1,12 +1,15 @@
 package helpers
 
 import (
+	"bufio"
 	"encoding/csv"
 	"errors"
 	iface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
 	"github.com/multiformats/go-multiaddr"
+	"ipfs-connect2all/annotation"
 	"os"
 	"strconv"
 	"strings"
@@ -93,21 +96,78 @@ func CheckOrCreateDir(path string) error {
 	return nil
 }
 
+// write rows to a tab-separated file, e.g., an analysis result with non-numerical columns
+func WriteTsvFile(filename string, elements [][]string) error {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	csvWriter := csv.NewWriter(f)
+	csvWriter.Comma = '\t'
+	return csvWriter.WriteAll(elements)
+}
+
 func WriteToCsv(prefix string, snapshotDir string, dateFormat string, elements [][]string) error {
 	formattedDate := time.Now().Format(dateFormat)
 	filename := snapshotDir + "/" + prefix + "_" + formattedDate + ".csv"
+	return WriteCsvFile(filename, elements)
+}
+
+// write rows to a ';'-separated file, like the snapshots
+func WriteCsvFile(filename string, elements [][]string) error {
+	return WriteCompressedCsvFile(filename, elements, CompressionNone)
+}
+
+// like WriteCsvFile, but compressed (see NewCompressedWriter)
+func WriteCompressedCsvFile(filename string, elements [][]string, compression string) error {
 	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	csvWriter := csv.NewWriter(f)
+	err = writeCompressedCsv(f, elements, compression)
+	if err == nil {
+		// on disk before the file is renamed (see SnapshotBundle)
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func writeCompressedCsv(f *os.File, elements [][]string, compression string) error {
+	bufferedWriter := bufio.NewWriter(f)
+	compressedWriter, err := NewCompressedWriter(bufferedWriter, compression)
+	if err != nil {
+		return err
+	}
+	csvWriter := csv.NewWriter(compressedWriter)
 	csvWriter.Comma = ';'
 	err = csvWriter.WriteAll(elements)
 	if err != nil {
 		return err
 	}
-	return nil
+	err = compressedWriter.Close()
+	if err != nil {
+		return err
+	}
+	return bufferedWriter.Flush()
+}
+
+// sync a directory, so that renames of files in it are on disk
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	closeErr := d.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
 }
 
 func TransformSliceForCsv(in []string) [][]string {
@@ -130,6 +190,35 @@ func TransformMAMapForCsv(in map[peer.ID][]multiaddr.Multiaddr) [][]string {
 	return out
 }
 
+// like TransformMAMapForCsv, but with country and ASN columns after the peer ID
+func TransformMAMapForCsvWithAnnotations(in map[peer.ID][]multiaddr.Multiaddr,
+	annotator *annotation.Annotator) [][]string {
+	out := make([][]string, len(in))
+	i := 0
+	for e, addrs := range in {
+		out[i] = append([]string{e.String()}, annotator.LookupAddrs(addrs).CsvColumns()...)
+		i++
+	}
+	return out
+}
+
+// like TransformMAMapForCsvWithAnnotations, but with the address columns (see AddrSummary) after the country and
+// ASN columns, which are empty if annotator is nil
+func TransformMAMapForCsvWithAddrs(in map[peer.ID][]multiaddr.Multiaddr,
+	annotator *annotation.Annotator) [][]string {
+	out := make([][]string, len(in))
+	i := 0
+	for e, addrs := range in {
+		annotationColumns := []string{"", ""}
+		if annotator != nil {
+			annotationColumns = annotator.LookupAddrs(addrs).CsvColumns()
+		}
+		out[i] = append(append([]string{e.String()}, annotationColumns...), NewAddrSummary(addrs).CsvColumns()...)
+		i++
+	}
+	return out
+}
+
 func TransformBoolMapForCsv(in map[peer.ID]bool) [][]string {
 	out := make([][]string, len(in))
 	i := 0
@@ -141,6 +230,18 @@ func TransformBoolMapForCsv(in map[peer.ID]bool) [][]string {
 	return out
 }
 
+func TransformIntMapForCsv(in map[peer.ID]int) [][]string {
+	out := make([][]string, len(in))
+	i := 0
+	for e, v := range in {
+		out[i] = make([]string, 2)
+		out[i][0] = e.String()
+		out[i][1] = strconv.Itoa(v)
+		i++
+	}
+	return out
+}
+
 func SupportedProtocolsToString(in []protocol.ID) string {
 	inStr := protocol.ConvertToStrings(in)
 	return strings.Join(inStr, ",")
@@ -151,16 +252,45 @@ func SupportedProtocolsFromString(in string) []protocol.ID {
 	return protocol.ConvertFromStrings(retStr)
 }
 
-func TransformConnInfoSliceForCsv(in []iface.ConnectionInfo) [][]string {
+// rows of connected peers: peer ID, direction, stream protocols, remote multiaddr, transport, connection age in
+// seconds (relative to now), agent version, latency in ms and number of open streams; columns which are not
+// available are empty
+func TransformConnInfoSliceForCsv(in []iface.ConnectionInfo, h host.Host, now time.Time) [][]string {
 	out := make([][]string, len(in))
 	for i, e := range in {
-		out[i] = make([]string, 3)
+		out[i] = make([]string, 9)
 		out[i][0] = e.ID().String()
 		out[i][1] = strconv.Itoa(int(e.Direction()))
 		eStreams, err := e.Streams()
 		if err == nil {
 			out[i][2] = SupportedProtocolsToString(eStreams)
 		}
+		if addr := e.Address(); addr != nil {
+			out[i][3] = addr.String()
+			out[i][4] = GetTransport(addr)
+		}
+		if latency, err := e.Latency(); err == nil && latency > 0 {
+			out[i][7] = strconv.FormatFloat(float64(latency)/float64(time.Millisecond), 'f', 3, 64)
+		}
+		if h == nil {
+			continue
+		}
+		if agentVersion, err := h.Peerstore().Get(e.ID(), "AgentVersion"); err == nil {
+			if agentVersionString, ok := agentVersion.(string); ok {
+				out[i][6] = agentVersionString
+			}
+		}
+		// connection with the address of the connection info (one per connection)
+		for _, conn := range h.Network().ConnsToPeer(e.ID()) {
+			if e.Address() != nil && !conn.RemoteMultiaddr().Equal(e.Address()) {
+				continue
+			}
+			if opened := conn.Stat().Opened; !opened.IsZero() {
+				out[i][5] = strconv.FormatInt(int64(now.Sub(opened)/time.Second), 10)
+			}
+			out[i][8] = strconv.Itoa(len(conn.GetStreams()))
+			break
+		}
 	}
 	return out
 }
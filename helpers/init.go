@@ -13,17 +13,21 @@ import (
 	"github.com/ipfs/go-ipfs/plugin/loader"
 	"github.com/ipfs/go-ipfs/repo/fsrepo"
 	iface "github.com/ipfs/interface-go-ipfs-core"
+	p2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
 	"time"
 )
 
-// spawn node on temporary repository
-func InitIpfs(ctx context.Context, connMgrType string, connMgrHighWater int, portPrefix string) iface.CoreAPI {
+// spawn node on temporary repository; gater may be nil
+func InitIpfs(ctx context.Context, connMgrType string, connMgrHighWater int, portPrefix string,
+	gater *DialGater) (iface.CoreAPI, *core.IpfsNode) {
 
 	// some of the initialization steps are taken from the example go-ipfs-as-a-library in the go-ipfs project
 
@@ -81,6 +85,13 @@ func InitIpfs(ctx context.Context, connMgrType string, connMgrHighWater int, por
 		// Routing: libp2p.DHTClientOption, // This option sets the node to be a client DHT node (only fetching records)
 		Repo: repo,
 	}
+	if gater != nil {
+		// all dials of the node go through the gater, not only those of connect2all
+		nodeOptions.Host = func(ctx context.Context, id peer.ID, ps peerstore.Peerstore,
+			options ...p2p.Option) (host.Host, error) {
+			return libp2p.DefaultHostOption(ctx, id, ps, append(options, gater.Option())...)
+		}
+	}
 	node, err := core.NewNode(ctx, nodeOptions)
 	if err != nil {
 		panic(err)
@@ -91,81 +102,99 @@ func InitIpfs(ctx context.Context, connMgrType string, connMgrHighWater int, por
 	}
 	fmt.Println("IPFS node created successfully! Peer ID: " + cfg.Identity.PeerID)
 
-	return ipfs
+	return ipfs, node
 }
 
+// cacheFilter selects the peers whose wantlists are cached and logged (filter nil: all peers), its peers are
+// updated with the connected peers of the node; compress enables gzip compression of the wantlistLog files;
+// failed snapshots are logged, recorded in status, and retried with the next snapshot; eventLog (may be nil) is
+// fed by polling the cache between the snapshots
 func InitWantlistAnalysis(outfileDir string, snapshotInterval time.Duration, resetCache bool, dateFormat string,
-	wantlistOfPeers map[peer.ID]bool) {
-	decision.SetWantlistFilter(wantlistOfPeers)
+	cacheFilter *WantlistCacheFilter, peerNetwork network.Network, compress bool, status *WantlistStatus,
+	eventLog *WantlistEventLog) {
+	filter := cacheFilter.filter
+	cacheFilter.start(peerNetwork)
 	decision.EnableWantlistCaching(true)
 	go func() {
-		err := CheckOrCreateDir(outfileDir)
-		if err != nil {
-			return
-		}
+		// wantlists from a reset cache which could not be written yet
+		var pending map[peer.ID]map[cid.Cid]message.WantlistCacheEntry
 
 		for {
-			time.Sleep(snapshotInterval)
+			waitForWantlistSnapshot(snapshotInterval, eventLog)
+			// drop disconnected peers and peers no longer allowed
+			cacheFilter.Refresh()
 			var wantLists map[peer.ID]map[cid.Cid]message.WantlistCacheEntry
 			if resetCache {
 				wantLists = decision.GetAndResetWantlistCache()
+				if eventLog != nil {
+					eventLog.RecordCache(wantLists, true)
+				}
+				if pending != nil {
+					wantLists = mergeWantlists(pending, wantLists)
+					pending = nil
+				}
 			} else {
 				wantLists = decision.GetWantlistCache()
-			}
-			formattedDate := time.Now().Format(dateFormat)
-			filename := outfileDir + "/wantlistLog_" + formattedDate + ".json"
-			f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-			if err != nil {
-				return
-			}
-			// TODO write json, problem: only strings as map types are allowed -> manually or write converter?
-			var sb strings.Builder
-			sb.WriteString("{\n")
-			first0 := true
-			for peerID, entryMap := range wantLists {
-				if first0 {
-					first0 = false
-				} else {
-					sb.WriteString(",\n")
-				}
-				sb.WriteByte('"')
-				sb.WriteString(peerID.Pretty())
-				sb.WriteString("\" : {\n")
-				first1 := true
-				for contentID, entry := range entryMap {
-					if first1 {
-						first1 = false
-					} else {
-						sb.WriteString(",\n")
-					}
-					sb.WriteByte('"')
-					sb.WriteString(contentID.String())
-					sb.WriteString("\" : { \"FirstWantHave\": \"")
-					sb.WriteString(entry.FirstWantHave.String())
-					sb.WriteString("\", \"LastWantHave\": \"")
-					sb.WriteString(entry.LastWantHave.String())
-					sb.WriteString("\", \"NumWantHave\": ")
-					sb.WriteString(strconv.Itoa(entry.NumWantHave))
-					sb.WriteString(", \"FirstWantBlock\": \"")
-					sb.WriteString(entry.FirstWantBlock.String())
-					sb.WriteString("\", \"LastWantBlock\": \"")
-					sb.WriteString(entry.LastWantBlock.String())
-					sb.WriteString("\", \"NumWantBlock\": ")
-					sb.WriteString(strconv.Itoa(entry.NumWantBlock))
-					sb.WriteString(" }")
+				if eventLog != nil {
+					eventLog.RecordCache(wantLists, false)
 				}
-				sb.WriteString("\n}")
 			}
-			sb.WriteString("\n}")
-			_, err = f.WriteString(sb.String())
-			if err != nil {
-				return
+
+			err := CheckOrCreateDir(outfileDir)
+			if err == nil {
+				now := time.Now()
+				filename := outfileDir + "/wantlistLog_" + now.Format(dateFormat) + ".json"
+				if compress {
+					filename += ".gz"
+				}
+				err = writeWantlistLogAtomically(filename, now, wantLists, filter, compress)
 			}
-			err = f.Sync()
 			if err != nil {
-				return
+				status.RecordFailure(err)
+				_, _, consecutiveFailures := status.Counts()
+				log.Printf("Could not write wantlist snapshot (%d failed in a row), retrying with the next one: %s",
+					consecutiveFailures, err)
+				if resetCache {
+					pending = wantLists
+				}
+				continue
 			}
-			f.Close()
+			status.RecordSuccess()
 		}
 	}()
 }
+
+// sleep for snapshotInterval, polling the wantlist cache for the event log (if not nil) in the meantime
+func waitForWantlistSnapshot(snapshotInterval time.Duration, eventLog *WantlistEventLog) {
+	if eventLog == nil {
+		time.Sleep(snapshotInterval)
+		return
+	}
+	deadline := time.Now().Add(snapshotInterval)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= eventLog.interval {
+			time.Sleep(remaining)
+			return
+		}
+		time.Sleep(eventLog.interval)
+		eventLog.RecordCache(decision.GetWantlistCache(), false)
+	}
+}
+
+// write wantlistLog to a temporary file first, so that only complete files appear under filename
+func writeWantlistLogAtomically(filename string, timestamp time.Time,
+	wantLists map[peer.ID]map[cid.Cid]message.WantlistCacheEntry, filter WantlistFilter, compress bool) error {
+	tmpFilename := filename + ".tmp"
+	err := WriteWantlistLog(tmpFilename, timestamp, wantLists, filter, compress)
+	if err != nil {
+		_ = os.Remove(tmpFilename)
+		return err
+	}
+	err = os.Rename(tmpFilename, filename)
+	if err != nil {
+		_ = os.Remove(tmpFilename)
+		return err
+	}
+	return nil
+}
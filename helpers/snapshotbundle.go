@@ -0,0 +1,230 @@
+package helpers
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// version of the manifest format, increased on incompatible changes
+const SnapshotManifestVersion = 1
+
+// name of the per-day subdirectories of sharded snapshots
+const SnapshotShardFormat = "2006-01-02"
+
+// manifest_<date>.json, written after all files of a snapshot bundle
+type SnapshotManifest struct {
+	Version int
+	// time of the snapshot, shared by all files
+	Timestamp time.Time
+	// all files have been written
+	Complete bool
+	// compression of the files (none, gzip or zstd)
+	Compression string
+	// csv or json (one snapshot document, prefix snapshot)
+	Format string
+	// effective timeout per connection attempt of the run, e.g. 30s, 0s if the libp2p defaults are used
+	DialTimeout string
+	Files       []SnapshotManifestFile
+	Failed      []SnapshotManifestFailure
+}
+
+type SnapshotManifestFile struct {
+	// file type, e.g., known or connected
+	Prefix string
+	// name without directory (the directory of the manifest)
+	Filename string
+	Rows     int
+}
+
+type SnapshotManifestFailure struct {
+	Prefix string
+	Error  string
+}
+
+// snapshot files of one round, sharing the timestamp of the bundle; files are written to temporary files first
+// and renamed by Commit, which writes the manifest last; with SnapshotFormatJson, the rows are collected and written
+// as one document by Commit
+type SnapshotBundle struct {
+	dir           string
+	formattedDate string
+	compression   string
+	format        string
+	manifest      SnapshotManifest
+	// rows of each added snapshot type, only with SnapshotFormatJson
+	document *SnapshotDocument
+	// temporary file of each added file
+	tmpFiles   []string
+	dirChecked bool
+}
+
+// shardByDay writes the bundle to a subdirectory of snapshotDir for the day (YYYY-MM-DD, local time);
+// compression must be valid (see CheckCompression), format is SnapshotFormatCsv or SnapshotFormatJson
+func NewSnapshotBundle(snapshotDir string, dateFormat string, compression string, shardByDay bool,
+	format string) *SnapshotBundle {
+	now := time.Now()
+	if shardByDay {
+		snapshotDir += "/" + now.Format(SnapshotShardFormat)
+	}
+	ret := &SnapshotBundle{
+		dir:           snapshotDir,
+		formattedDate: now.Format(dateFormat),
+		compression:   compression,
+		format:        format,
+		manifest: SnapshotManifest{
+			Version:     SnapshotManifestVersion,
+			Timestamp:   now.UTC(),
+			Compression: compression,
+			Format:      format,
+			Files:       make([]SnapshotManifestFile, 0),
+			Failed:      make([]SnapshotManifestFailure, 0),
+		},
+		tmpFiles: make([]string, 0),
+	}
+	if format == SnapshotFormatJson {
+		ret.document = &SnapshotDocument{Version: SnapshotDocumentVersion, Timestamp: now.UTC()}
+	}
+	return ret
+}
+
+func (b *SnapshotBundle) Timestamp() time.Time {
+	return b.manifest.Timestamp
+}
+
+// record the dial timeout of the run in the manifest
+func (b *SnapshotBundle) SetDialTimeout(dialTimeout time.Duration) {
+	b.manifest.DialTimeout = dialTimeout.String()
+}
+
+// write rows to the temporary file for <prefix>_<date>.csv (with the extension of the compression),
+// errors are recorded as failure
+func (b *SnapshotBundle) Add(prefix string, elements [][]string) error {
+	if b.document != nil {
+		err := b.document.addRows(prefix, elements)
+		if err != nil {
+			b.AddFailure(prefix, err)
+		}
+		return err
+	}
+	if err := b.checkDir(); err != nil {
+		b.AddFailure(prefix, err)
+		return err
+	}
+	filename := prefix + "_" + b.formattedDate + ".csv" + CompressionExtension(b.compression)
+	tmpFilename := b.dir + "/" + filename + ".tmp"
+	err := WriteCompressedCsvFile(tmpFilename, elements, b.compression)
+	if err != nil {
+		_ = os.Remove(tmpFilename)
+		b.AddFailure(prefix, err)
+		return err
+	}
+	b.manifest.Files = append(b.manifest.Files, SnapshotManifestFile{
+		Prefix:   prefix,
+		Filename: filename,
+		Rows:     len(elements),
+	})
+	b.tmpFiles = append(b.tmpFiles, tmpFilename)
+	return nil
+}
+
+func (b *SnapshotBundle) checkDir() error {
+	if b.dirChecked {
+		return nil
+	}
+	if err := CheckOrCreateDir(b.dir); err != nil {
+		return err
+	}
+	b.dirChecked = true
+	return nil
+}
+
+// record file which could not be collected or written, the bundle is incomplete then
+func (b *SnapshotBundle) AddFailure(prefix string, err error) {
+	b.manifest.Failed = append(b.manifest.Failed, SnapshotManifestFailure{Prefix: prefix, Error: err.Error()})
+}
+
+// rename the added files (resp. write the document) and write the manifest; files which cannot be renamed are
+// recorded as failure
+func (b *SnapshotBundle) Commit() error {
+	if b.document != nil {
+		b.writeDocument()
+	}
+	files := b.manifest.Files[:0]
+	for i, file := range b.manifest.Files {
+		err := os.Rename(b.tmpFiles[i], b.dir+"/"+file.Filename)
+		if err != nil {
+			_ = os.Remove(b.tmpFiles[i])
+			b.AddFailure(file.Prefix, err)
+			continue
+		}
+		files = append(files, file)
+	}
+	b.manifest.Files = files
+	b.tmpFiles = b.tmpFiles[:0]
+	b.manifest.Complete = len(b.manifest.Failed) == 0
+
+	if err := b.checkDir(); err != nil {
+		return errors.New("Could not write snapshot manifest: " + err.Error())
+	}
+	filename := b.dir + "/manifest_" + b.formattedDate + ".json"
+	tmpFilename := filename + ".tmp"
+	err := writeSnapshotManifest(tmpFilename, &b.manifest)
+	if err == nil {
+		err = os.Rename(tmpFilename, filename)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFilename)
+		return errors.New("Could not write snapshot manifest: " + err.Error())
+	}
+	// make the renames of the files and the manifest durable
+	if err := SyncDir(b.dir); err != nil {
+		return errors.New("Could not sync snapshot directory: " + err.Error())
+	}
+	return nil
+}
+
+// write the document to a temporary file, which is renamed with the other files
+func (b *SnapshotBundle) writeDocument() {
+	if err := b.checkDir(); err != nil {
+		b.AddFailure("snapshot", err)
+		return
+	}
+	b.document.Errors = b.manifest.Failed
+	b.document.Complete = len(b.document.Errors) == 0
+	filename := "snapshot_" + b.formattedDate + ".json" + CompressionExtension(b.compression)
+	tmpFilename := b.dir + "/" + filename + ".tmp"
+	err := writeSnapshotDocument(tmpFilename, b.document, b.compression)
+	if err != nil {
+		_ = os.Remove(tmpFilename)
+		b.AddFailure("snapshot", err)
+		return
+	}
+	rows := len(b.document.Known) + len(b.document.Connected) + len(b.document.Established) +
+		len(b.document.Successful) + len(b.document.Failed) + len(b.document.TimedOut) + len(b.document.Sources) +
+		len(b.document.Filtered)
+	b.manifest.Files = append(b.manifest.Files, SnapshotManifestFile{
+		Prefix:   "snapshot",
+		Filename: filename,
+		Rows:     rows,
+	})
+	b.tmpFiles = append(b.tmpFiles, tmpFilename)
+}
+
+func writeSnapshotManifest(filename string, manifest *SnapshotManifest) error {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(manifest)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
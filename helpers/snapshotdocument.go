@@ -0,0 +1,247 @@
+package helpers
+
+import (
+	"encoding/json"
+	"errors"
+	"ipfs-connect2all/annotation"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// one file per snapshot type (known_, connected_, ...)
+	SnapshotFormatCsv = "csv"
+	// one snapshot_*.json document per snapshot, see SnapshotDocument
+	SnapshotFormatJson = "json"
+)
+
+// version of the snapshot document format, increased on incompatible changes
+const SnapshotDocumentVersion = 1
+
+// all snapshot types of a round in one document (SnapshotFormatJson); peer lists are empty if they could not be
+// collected, see Errors
+type SnapshotDocument struct {
+	Version   int
+	Timestamp time.Time
+	// all peer lists have been collected
+	Complete    bool
+	Errors      []SnapshotManifestFailure `json:",omitempty"`
+	Known       []SnapshotKnownPeer
+	Connected   []SnapshotConnectedPeer
+	Established []string
+	Successful  []string
+	Failed      []string
+	// only with DialTimeout
+	TimedOut []string `json:",omitempty"`
+	// peer source of the first connection attempt by peer ID
+	Sources map[string]string `json:",omitempty"`
+	// number of addresses removed by the dial filter by peer ID, only with dial filter
+	Filtered map[string]int `json:",omitempty"`
+}
+
+type SnapshotKnownPeer struct {
+	PeerID string
+	// only with GeoDatabases
+	Country string `json:",omitempty"`
+	ASN     uint32 `json:",omitempty"`
+	// only with SnapshotKnownAddrs
+	Addrs *SnapshotAddrs `json:",omitempty"`
+}
+
+// see AddrSummary
+type SnapshotAddrs struct {
+	NumAddrs int
+	IPv4     bool
+	IPv6     bool
+	TCP      bool
+	QUIC     bool
+	Public   bool
+	Addrs    []string
+}
+
+type SnapshotConnectedPeer struct {
+	PeerID string
+	// as in connected_ files (network.Direction: 0 unknown, 1 inbound, 2 outbound)
+	Direction int
+	Protocols []string
+	// remote multiaddr and its transport (see GetTransport)
+	Addr      string `json:",omitempty"`
+	Transport string `json:",omitempty"`
+	// age of the connection in seconds at the time of the snapshot, only if known
+	Age          *int64 `json:",omitempty"`
+	AgentVersion string `json:",omitempty"`
+	// in ms, only if known
+	Latency float64 `json:",omitempty"`
+	// number of open streams, only if known
+	Streams *int `json:",omitempty"`
+}
+
+func peerIDColumn(rows [][]string) []string {
+	ret := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if len(row) > 0 {
+			ret = append(ret, row[0])
+		}
+	}
+	return ret
+}
+
+// convert the rows of a snapshot type (as written to the CSV files) into the document
+func (d *SnapshotDocument) addRows(prefix string, rows [][]string) error {
+	switch prefix {
+	case "known":
+		d.Known = make([]SnapshotKnownPeer, 0, len(rows))
+		for _, row := range rows {
+			knownPeer := SnapshotKnownPeer{PeerID: row[0]}
+			if len(row) >= 3 && row[1] != "" {
+				peerAnnotation, err := annotation.FromCsvColumns(row[1:3])
+				if err != nil {
+					return err
+				}
+				knownPeer.Country = peerAnnotation.Country
+				knownPeer.ASN = peerAnnotation.ASN
+			}
+			if len(row) >= 10 {
+				summary, err := AddrSummaryFromCsvColumns(row[3:10])
+				if err != nil {
+					return err
+				}
+				knownPeer.Addrs = &SnapshotAddrs{
+					NumAddrs: summary.NumAddrs,
+					IPv4:     summary.IPv4,
+					IPv6:     summary.IPv6,
+					TCP:      summary.TCP,
+					QUIC:     summary.QUIC,
+					Public:   summary.Public,
+					Addrs:    make([]string, 0, len(summary.Addrs)),
+				}
+				if row[9] != "" {
+					knownPeer.Addrs.Addrs = strings.Split(row[9], ",")
+				}
+			}
+			d.Known = append(d.Known, knownPeer)
+		}
+	case "connected":
+		d.Connected = make([]SnapshotConnectedPeer, 0, len(rows))
+		for _, row := range rows {
+			if len(row) < 1 {
+				return errors.New("Invalid row length of connected peers (should be at least 1)")
+			}
+			connectedPeer := SnapshotConnectedPeer{
+				PeerID:    row[0],
+				Protocols: make([]string, 0),
+			}
+			// the oldest snapshots only contain the peer ID
+			if len(row) >= 3 {
+				direction, err := strconv.Atoi(row[1])
+				if err != nil {
+					return err
+				}
+				connectedPeer.Direction = direction
+				if row[2] != "" {
+					connectedPeer.Protocols = strings.Split(row[2], ",")
+				}
+			}
+			// not in older snapshots
+			if len(row) >= 9 {
+				connectedPeer.Addr = row[3]
+				connectedPeer.Transport = row[4]
+				connectedPeer.AgentVersion = row[6]
+				if row[5] != "" {
+					age, err := strconv.ParseInt(row[5], 10, 64)
+					if err != nil {
+						return err
+					}
+					connectedPeer.Age = &age
+				}
+				if row[7] != "" {
+					latency, err := strconv.ParseFloat(row[7], 64)
+					if err != nil {
+						return err
+					}
+					connectedPeer.Latency = latency
+				}
+				if row[8] != "" {
+					streams, err := strconv.Atoi(row[8])
+					if err != nil {
+						return err
+					}
+					connectedPeer.Streams = &streams
+				}
+			}
+			d.Connected = append(d.Connected, connectedPeer)
+		}
+	case "established":
+		d.Established = peerIDColumn(rows)
+	case "successful":
+		d.Successful = peerIDColumn(rows)
+	case "failed":
+		d.Failed = peerIDColumn(rows)
+	case "timedout":
+		d.TimedOut = peerIDColumn(rows)
+	case "sources":
+		d.Sources = make(map[string]string, len(rows))
+		for _, row := range rows {
+			if len(row) >= 2 {
+				d.Sources[row[0]] = row[1]
+			}
+		}
+	case "filtered":
+		d.Filtered = make(map[string]int, len(rows))
+		for _, row := range rows {
+			if len(row) < 2 {
+				continue
+			}
+			filtered, err := strconv.Atoi(row[1])
+			if err != nil {
+				return err
+			}
+			d.Filtered[row[0]] = filtered
+		}
+	default:
+		return errors.New("Unknown snapshot type: " + prefix)
+	}
+	return nil
+}
+
+// document from the rows of each snapshot type (e.g., as stored in the database)
+func NewSnapshotDocumentFromRows(timestamp time.Time, rows map[string][][]string,
+	failed []SnapshotManifestFailure) (*SnapshotDocument, error) {
+	ret := &SnapshotDocument{
+		Version:   SnapshotDocumentVersion,
+		Timestamp: timestamp,
+		Complete:  len(failed) == 0,
+		Errors:    failed,
+	}
+	for prefix, prefixRows := range rows {
+		if err := ret.addRows(prefix, prefixRows); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func writeSnapshotDocument(filename string, document *SnapshotDocument, compression string) error {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	compressedWriter, err := NewCompressedWriter(f, compression)
+	if err == nil {
+		err = json.NewEncoder(compressedWriter).Encode(document)
+		if closeErr := compressedWriter.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		// on disk before the file is renamed (see SnapshotBundle)
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
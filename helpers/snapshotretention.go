@@ -0,0 +1,181 @@
+package helpers
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// retention policy for snapshot bundles, only bundles with manifest are deleted
+type SnapshotRetention struct {
+	// bundles older than this are thinned out to the first bundle of each hour, 0: keep all
+	HourlyAfter time.Duration
+	// maximum total size of the bundles in bytes, the oldest bundles are deleted first, 0: unlimited
+	MaxTotalSize int64
+}
+
+func (r SnapshotRetention) IsActive() bool {
+	return r.HourlyAfter > 0 || r.MaxTotalSize > 0
+}
+
+type retainedBundle struct {
+	dir          string
+	manifestFile string
+	manifest     SnapshotManifest
+	size         int64
+}
+
+func (b retainedBundle) delete() error {
+	var ret error
+	for _, file := range b.manifest.Files {
+		err := os.Remove(b.dir + "/" + file.Filename)
+		if err != nil && !os.IsNotExist(err) && ret == nil {
+			ret = err
+		}
+	}
+	// manifest last, so that an interrupted deletion is retried
+	if ret != nil {
+		return ret
+	}
+	return os.Remove(b.manifestFile)
+}
+
+// manifest_*.json files in dir and its per-day shards, and the shards
+func findSnapshotManifests(dir string) ([]string, []string, error) {
+	entries, err := readDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifests := make([]string, 0)
+	subdirs := make([]string, 0)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if _, err := time.Parse(SnapshotShardFormat, entry.Name()); err != nil {
+				continue
+			}
+			subdirs = append(subdirs, dir+"/"+entry.Name())
+			subEntries, err := readDir(dir + "/" + entry.Name())
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, subEntry := range subEntries {
+				if isSnapshotManifest(subEntry) {
+					manifests = append(manifests, dir+"/"+entry.Name()+"/"+subEntry.Name())
+				}
+			}
+		} else if isSnapshotManifest(entry) {
+			manifests = append(manifests, dir+"/"+entry.Name())
+		}
+	}
+	return manifests, subdirs, nil
+}
+
+func readDir(dir string) ([]os.FileInfo, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdir(-1)
+}
+
+func isSnapshotManifest(info os.FileInfo) bool {
+	return !info.IsDir() && strings.HasPrefix(info.Name(), "manifest_") && strings.HasSuffix(info.Name(), ".json")
+}
+
+func loadRetainedBundle(manifestFile string) (retainedBundle, error) {
+	ret := retainedBundle{manifestFile: manifestFile, dir: manifestFile[:strings.LastIndexByte(manifestFile, '/')]}
+	f, err := os.Open(manifestFile)
+	if err != nil {
+		return ret, err
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&ret.manifest)
+	if err != nil {
+		return ret, err
+	}
+	if stat, err := f.Stat(); err == nil {
+		ret.size = stat.Size()
+	}
+	for _, file := range ret.manifest.Files {
+		if stat, err := os.Stat(ret.dir + "/" + file.Filename); err == nil {
+			ret.size += stat.Size()
+		}
+	}
+	return ret, nil
+}
+
+// delete snapshot bundles in snapshotDir according to the policy, returns the number of deleted bundles; the
+// newest bundle is never deleted
+func ApplySnapshotRetention(snapshotDir string, retention SnapshotRetention, now time.Time) (int, error) {
+	manifestFiles, subdirs, err := findSnapshotManifests(snapshotDir)
+	if err != nil {
+		return 0, errors.New("Could not list snapshot bundles: " + err.Error())
+	}
+	bundles := make([]retainedBundle, 0, len(manifestFiles))
+	for _, manifestFile := range manifestFiles {
+		bundle, err := loadRetainedBundle(manifestFile)
+		if err != nil {
+			// e.g., written by another process at the moment
+			continue
+		}
+		bundles = append(bundles, bundle)
+	}
+	sort.Slice(bundles, func(i, j int) bool {
+		return bundles[i].manifest.Timestamp.Before(bundles[j].manifest.Timestamp)
+	})
+	if len(bundles) < 2 {
+		return 0, nil
+	}
+	newest := bundles[len(bundles)-1]
+	bundles = bundles[:len(bundles)-1]
+
+	deleted := 0
+	var firstErr error
+	deleteBundle := func(bundle retainedBundle) bool {
+		if err := bundle.delete(); err != nil {
+			if firstErr == nil {
+				firstErr = errors.New("Could not delete snapshot bundle: " + err.Error())
+			}
+			return false
+		}
+		deleted++
+		return true
+	}
+
+	totalSize := newest.size
+	remaining := make([]retainedBundle, 0, len(bundles))
+	lastHour := time.Time{}
+	for _, bundle := range bundles {
+		hour := bundle.manifest.Timestamp.Truncate(time.Hour)
+		if retention.HourlyAfter > 0 && now.Sub(bundle.manifest.Timestamp) > retention.HourlyAfter &&
+			hour.Equal(lastHour) {
+			if deleteBundle(bundle) {
+				continue
+			}
+		}
+		lastHour = hour
+		remaining = append(remaining, bundle)
+		totalSize += bundle.size
+	}
+
+	if retention.MaxTotalSize > 0 {
+		for _, bundle := range remaining {
+			if totalSize <= retention.MaxTotalSize {
+				break
+			}
+			if deleteBundle(bundle) {
+				totalSize -= bundle.size
+			}
+		}
+	}
+
+	// remove per-day shards which are empty now (fails for non-empty directories)
+	for _, subdir := range subdirs {
+		_ = os.Remove(subdir)
+	}
+	return deleted, firstErr
+}
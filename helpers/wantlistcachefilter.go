@@ -0,0 +1,81 @@
+package helpers
+
+import (
+	"github.com/ipfs/go-bitswap/decision"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"sync"
+)
+
+// the bitswap wantlist cache uses the wantlists of all peers if its filter is empty, so an empty set of peers is
+// passed as this (invalid) peer ID
+const noWantlistCachePeer = peer.ID("")
+
+// keeps the peers of the bitswap wantlist cache (decision.SetWantlistFilter) in sync with the wantlist filter:
+// the peers are recomputed from the connected peers by Refresh (e.g., after a reload, and before each snapshot),
+// and newly connected peers are added as soon as they connect, before they can send their wantlists
+type WantlistCacheFilter struct {
+	filter WantlistFilter
+	mutex  *sync.Mutex
+	// nil until InitWantlistAnalysis
+	network network.Network
+	// nil: all peers
+	peers map[peer.ID]bool
+}
+
+// filter may be nil to cache the wantlists of all peers
+func NewWantlistCacheFilter(filter WantlistFilter) *WantlistCacheFilter {
+	return &WantlistCacheFilter{filter: filter, mutex: &sync.Mutex{}}
+}
+
+// recompute the peers from the connected peers and pass them to bitswap, no-op before InitWantlistAnalysis
+func (f *WantlistCacheFilter) Refresh() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.network == nil {
+		return
+	}
+	if f.filter == nil {
+		f.setPeers(nil)
+		return
+	}
+	f.setPeers(f.filter.CachePeers(f.network.Peers()))
+}
+
+func (f *WantlistCacheFilter) start(n network.Network) {
+	f.mutex.Lock()
+	f.network = n
+	f.mutex.Unlock()
+	n.Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			f.peerConnected(conn.RemotePeer())
+		},
+	})
+	f.Refresh()
+}
+
+func (f *WantlistCacheFilter) peerConnected(peerID peer.ID) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.peers == nil || f.peers[peerID] || !f.filter.IsAllowed(peerID) {
+		return
+	}
+	// bitswap may still use the old set, so it is copied
+	peers := make(map[peer.ID]bool, len(f.peers)+1)
+	for cachePeer := range f.peers {
+		peers[cachePeer] = true
+	}
+	peers[peerID] = true
+	f.setPeers(peers)
+}
+
+func (f *WantlistCacheFilter) setPeers(peers map[peer.ID]bool) {
+	f.peers = peers
+	cachePeers := peers
+	if peers == nil {
+		cachePeers = make(map[peer.ID]bool)
+	} else if len(peers) == 0 {
+		cachePeers = map[peer.ID]bool{noWantlistCachePeer: true}
+	}
+	decision.SetWantlistFilter(cachePeers)
+}
@@ -0,0 +1,254 @@
+package helpers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"github.com/ipfs/go-bitswap/message"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// requests of a peer for a CID since the previous event of the peer, CID and want type, derived from the
+// bitswap wantlist cache (see RecordCache)
+type WantlistEvent struct {
+	// time of the last of the requests (LastWantHave resp. LastWantBlock of the cache entry)
+	Timestamp time.Time
+	PeerID    string
+	Cid       string
+	// "have" or "block"
+	WantType string
+	// more than 1 if several requests were received within the poll interval
+	Count int
+}
+
+// writes wantlist events to JSONL files (one event per line), rotated in intervals; events are queued and dropped
+// if the writer cannot keep up
+type WantlistEventLog struct {
+	prefix         string
+	dateFormat     string
+	rotateInterval time.Duration
+	compress       bool
+	// poll interval of the wantlist cache
+	interval    time.Duration
+	peerAllowed func(peer.ID) bool
+	events      chan WantlistEvent
+
+	// also guards closed
+	countMutex *sync.Mutex
+	written    int
+	dropped    int
+	failed     int
+	closed     bool
+	// closed when Run has returned
+	done chan struct{}
+
+	// cache at the previous RecordCache, only used by the wantlist goroutine (see InitWantlistAnalysis)
+	previous map[peer.ID]map[cid.Cid]message.WantlistCacheEntry
+
+	// only used by the writer goroutine
+	file           *os.File
+	bufferedWriter *bufio.Writer
+	gzipWriter     *gzip.Writer
+	encoder        *json.Encoder
+	fileOpened     time.Time
+}
+
+const wantlistEventQueueSize = 65536
+
+// files are named <prefix>_<date>.jsonl (.jsonl.gz if compress is set), the wantlist cache is polled every
+// interval; peerAllowed may be nil
+func NewWantlistEventLog(prefix string, dateFormat string, rotateInterval time.Duration, compress bool,
+	interval time.Duration, peerAllowed func(peer.ID) bool) *WantlistEventLog {
+	return &WantlistEventLog{
+		prefix:         prefix,
+		dateFormat:     dateFormat,
+		rotateInterval: rotateInterval,
+		compress:       compress,
+		interval:       interval,
+		peerAllowed:    peerAllowed,
+		events:         make(chan WantlistEvent, wantlistEventQueueSize),
+		countMutex:     &sync.Mutex{},
+		done:           make(chan struct{}),
+		previous:       make(map[peer.ID]map[cid.Cid]message.WantlistCacheEntry),
+	}
+}
+
+// record the requests added to the wantlist cache since the previous call as events; reset must be set if the
+// cache has been reset after wantLists had been read, the next call then starts from an empty cache
+func (l *WantlistEventLog) RecordCache(wantLists map[peer.ID]map[cid.Cid]message.WantlistCacheEntry, reset bool) {
+	for peerID, entries := range wantLists {
+		if l.peerAllowed != nil && !l.peerAllowed(peerID) {
+			continue
+		}
+		previousEntries := l.previous[peerID]
+		for contentID, entry := range entries {
+			previous := previousEntries[contentID]
+			l.recordIncrease(peerID, contentID, "have", entry.NumWantHave, previous.NumWantHave, entry.LastWantHave)
+			l.recordIncrease(peerID, contentID, "block", entry.NumWantBlock, previous.NumWantBlock,
+				entry.LastWantBlock)
+		}
+	}
+	if reset {
+		l.previous = make(map[peer.ID]map[cid.Cid]message.WantlistCacheEntry)
+	} else {
+		l.previous = wantLists
+	}
+}
+
+// a count below the previous one means that the cache has been reset in between
+func (l *WantlistEventLog) recordIncrease(peerID peer.ID, contentID cid.Cid, wantType string, count int,
+	previousCount int, last time.Time) {
+	if count < previousCount {
+		previousCount = 0
+	}
+	if count == previousCount {
+		return
+	}
+	l.record(WantlistEvent{
+		Timestamp: last.UTC(),
+		PeerID:    peerID.Pretty(),
+		Cid:       contentID.String(),
+		WantType:  wantType,
+		Count:     count - previousCount,
+	})
+}
+
+// queue event for writing, never blocks; events after Close are ignored
+func (l *WantlistEventLog) record(event WantlistEvent) {
+	l.countMutex.Lock()
+	defer l.countMutex.Unlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.events <- event:
+	default:
+		l.dropped++
+	}
+}
+
+// number of written, dropped (queue full), and failed (write error) events
+func (l *WantlistEventLog) Counts() (int, int, int) {
+	l.countMutex.Lock()
+	defer l.countMutex.Unlock()
+	return l.written, l.dropped, l.failed
+}
+
+// write queued events until the event log is closed, flushing at least once per second
+func (l *WantlistEventLog) Run() {
+	defer close(l.done)
+	flushTicker := time.NewTicker(time.Second)
+	defer flushTicker.Stop()
+	for {
+		select {
+		case event, ok := <-l.events:
+			if !ok {
+				l.closeFile()
+				return
+			}
+			l.write(event)
+		case <-flushTicker.C:
+			if l.file != nil && time.Since(l.fileOpened) >= l.rotateInterval {
+				l.closeFile()
+			} else if l.bufferedWriter != nil {
+				if err := l.flush(); err != nil {
+					l.handleError(err)
+				}
+			}
+		}
+	}
+}
+
+// stop accepting events and wait until Run has written the remaining ones and closed the file
+func (l *WantlistEventLog) Close() {
+	l.countMutex.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.events)
+	}
+	l.countMutex.Unlock()
+	<-l.done
+}
+
+func (l *WantlistEventLog) write(event WantlistEvent) {
+	if l.file == nil {
+		if err := l.openFile(); err != nil {
+			l.countFailed()
+			l.handleError(err)
+			return
+		}
+	}
+	if err := l.encoder.Encode(event); err != nil {
+		l.countFailed()
+		l.handleError(err)
+		return
+	}
+	l.countMutex.Lock()
+	l.written++
+	l.countMutex.Unlock()
+}
+
+func (l *WantlistEventLog) countFailed() {
+	l.countMutex.Lock()
+	l.failed++
+	l.countMutex.Unlock()
+}
+
+// log error and start a new file with the next event
+func (l *WantlistEventLog) handleError(err error) {
+	log.Printf("Could not write wantlist event log, continuing with a new file: %s", err)
+	l.closeFile()
+}
+
+func (l *WantlistEventLog) openFile() error {
+	now := time.Now()
+	filename := l.prefix + "_" + now.Format(l.dateFormat) + ".jsonl"
+	if l.compress {
+		filename += ".gz"
+	}
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.New("Could not open wantlist event log file: " + err.Error())
+	}
+	l.file = f
+	l.fileOpened = now
+	l.bufferedWriter = bufio.NewWriter(f)
+	var w io.Writer = l.bufferedWriter
+	if l.compress {
+		l.gzipWriter = gzip.NewWriter(l.bufferedWriter)
+		w = l.gzipWriter
+	}
+	l.encoder = json.NewEncoder(w)
+	return nil
+}
+
+func (l *WantlistEventLog) flush() error {
+	if l.gzipWriter != nil {
+		if err := l.gzipWriter.Flush(); err != nil {
+			return err
+		}
+	}
+	return l.bufferedWriter.Flush()
+}
+
+func (l *WantlistEventLog) closeFile() {
+	if l.file == nil {
+		return
+	}
+	if l.gzipWriter != nil {
+		_ = l.gzipWriter.Close()
+	}
+	_ = l.bufferedWriter.Flush()
+	_ = l.file.Close()
+	l.file = nil
+	l.bufferedWriter = nil
+	l.gzipWriter = nil
+	l.encoder = nil
+}
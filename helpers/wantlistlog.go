@@ -0,0 +1,162 @@
+package helpers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/ipfs/go-bitswap/message"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// version of the wantlistLog format written by WriteWantlistLog, increased on incompatible changes
+// (version 0: hand-written format without Version field, keyed by peer ID and CID)
+const WantlistLogVersion = 1
+
+// header of a wantlistLog file, the peers follow in Peers (streamed, see WriteWantlistLog)
+type WantlistLog struct {
+	Version int
+	// time of the snapshot
+	Timestamp time.Time
+	// filter active when the snapshot was written, nil in older files
+	Filter *WantlistFilterMetadata `json:",omitempty"`
+	Peers  []WantlistLogPeer
+}
+
+// settings of the wantlist filter, recorded in each wantlistLog file
+type WantlistFilterMetadata struct {
+	// only wantlists of these peers are logged, all peers if empty
+	Peers []string
+	// share of peers logged, selected by hashed peer ID
+	SampleRate float64
+	// PeerAllowlist or PeerDenylist used
+	PeerLists bool
+}
+
+// decides which wantlists are logged, see input.WantlistFilter
+type WantlistFilter interface {
+	IsAllowed(peerID peer.ID) bool
+	// peers for the wantlist cache of bitswap (nil: all peers), selected from the connected peers if there is no
+	// fixed set of peers, see WantlistCacheFilter
+	CachePeers(connectedPeers []peer.ID) map[peer.ID]bool
+	Metadata() WantlistFilterMetadata
+}
+
+type WantlistLogPeer struct {
+	PeerID  string
+	Entries []WantlistLogEntry
+}
+
+// times are nil if there has been no such request
+type WantlistLogEntry struct {
+	Cid            string
+	FirstWantHave  *time.Time `json:",omitempty"`
+	LastWantHave   *time.Time `json:",omitempty"`
+	NumWantHave    int
+	FirstWantBlock *time.Time `json:",omitempty"`
+	LastWantBlock  *time.Time `json:",omitempty"`
+	NumWantBlock   int
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+func NewWantlistLogPeer(peerID peer.ID, entries map[cid.Cid]message.WantlistCacheEntry) WantlistLogPeer {
+	ret := WantlistLogPeer{
+		PeerID:  peerID.Pretty(),
+		Entries: make([]WantlistLogEntry, 0, len(entries)),
+	}
+	for contentID, entry := range entries {
+		ret.Entries = append(ret.Entries, WantlistLogEntry{
+			Cid:            contentID.String(),
+			FirstWantHave:  optionalTime(entry.FirstWantHave),
+			LastWantHave:   optionalTime(entry.LastWantHave),
+			NumWantHave:    entry.NumWantHave,
+			FirstWantBlock: optionalTime(entry.FirstWantBlock),
+			LastWantBlock:  optionalTime(entry.LastWantBlock),
+			NumWantBlock:   entry.NumWantBlock,
+		})
+	}
+	sort.Slice(ret.Entries, func(i, j int) bool {
+		return ret.Entries[i].Cid < ret.Entries[j].Cid
+	})
+	return ret
+}
+
+// write wantlists to a wantlistLog file (gzip-compressed if compress is set), one peer at a time;
+// filter may be nil
+func WriteWantlistLog(filename string, timestamp time.Time, wantLists map[peer.ID]map[cid.Cid]message.WantlistCacheEntry,
+	filter WantlistFilter, compress bool) error {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bufferedWriter := bufio.NewWriter(f)
+	var w io.Writer = bufferedWriter
+	var gzipWriter *gzip.Writer
+	if compress {
+		gzipWriter = gzip.NewWriter(bufferedWriter)
+		w = gzipWriter
+	}
+
+	// header, encoded separately so that the peers can be streamed
+	var filterMetadata *WantlistFilterMetadata
+	if filter != nil {
+		metadata := filter.Metadata()
+		filterMetadata = &metadata
+	}
+	header, err := json.Marshal(struct {
+		Version   int
+		Timestamp time.Time
+		Filter    *WantlistFilterMetadata `json:",omitempty"`
+	}{WantlistLogVersion, timestamp.UTC(), filterMetadata})
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(header[:len(header)-1]); err != nil {
+		return err
+	}
+	if _, err = io.WriteString(w, ",\"Peers\":[\n"); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	first := true
+	for peerID, entryMap := range wantLists {
+		if filter != nil && !filter.IsAllowed(peerID) {
+			continue
+		}
+		if !first {
+			if _, err = io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		if err = encoder.Encode(NewWantlistLogPeer(peerID, entryMap)); err != nil {
+			return err
+		}
+	}
+	if _, err = io.WriteString(w, "]}\n"); err != nil {
+		return err
+	}
+
+	if gzipWriter != nil {
+		if err = gzipWriter.Close(); err != nil {
+			return err
+		}
+	}
+	if err = bufferedWriter.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
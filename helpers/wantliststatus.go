@@ -0,0 +1,88 @@
+package helpers
+
+import (
+	"github.com/ipfs/go-bitswap/message"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"sync"
+	"time"
+)
+
+// outcome of the wantlist snapshots of a run, safe for concurrent use
+type WantlistStatus struct {
+	mutex               *sync.Mutex
+	written             int
+	failed              int
+	consecutiveFailures int
+	lastError           string
+	lastErrorTime       time.Time
+	lastSuccessTime     time.Time
+}
+
+func NewWantlistStatus() *WantlistStatus {
+	return &WantlistStatus{mutex: &sync.Mutex{}}
+}
+
+func (s *WantlistStatus) RecordSuccess() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.written++
+	s.consecutiveFailures = 0
+	s.lastSuccessTime = time.Now()
+}
+
+func (s *WantlistStatus) RecordFailure(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failed++
+	s.consecutiveFailures++
+	s.lastError = err.Error()
+	s.lastErrorTime = time.Now()
+}
+
+// number of written snapshots, failed attempts, and failed attempts since the last written snapshot
+// (0 if the wantlist snapshots are healthy)
+func (s *WantlistStatus) Counts() (int, int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.written, s.failed, s.consecutiveFailures
+}
+
+// merge the wantlists of a snapshot which could not be written into newer ones (both from a reset cache)
+func mergeWantlists(older map[peer.ID]map[cid.Cid]message.WantlistCacheEntry,
+	newer map[peer.ID]map[cid.Cid]message.WantlistCacheEntry) map[peer.ID]map[cid.Cid]message.WantlistCacheEntry {
+	minTime := func(a time.Time, b time.Time) time.Time {
+		if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+			return b
+		}
+		return a
+	}
+	maxTime := func(a time.Time, b time.Time) time.Time {
+		if b.After(a) {
+			return b
+		}
+		return a
+	}
+	for peerID, olderEntries := range older {
+		newerEntries, exists := newer[peerID]
+		if !exists {
+			newer[peerID] = olderEntries
+			continue
+		}
+		for contentID, olderEntry := range olderEntries {
+			newerEntry, exists := newerEntries[contentID]
+			if !exists {
+				newerEntries[contentID] = olderEntry
+				continue
+			}
+			newerEntry.FirstWantHave = minTime(olderEntry.FirstWantHave, newerEntry.FirstWantHave)
+			newerEntry.LastWantHave = maxTime(olderEntry.LastWantHave, newerEntry.LastWantHave)
+			newerEntry.NumWantHave += olderEntry.NumWantHave
+			newerEntry.FirstWantBlock = minTime(olderEntry.FirstWantBlock, newerEntry.FirstWantBlock)
+			newerEntry.LastWantBlock = maxTime(olderEntry.LastWantBlock, newerEntry.LastWantBlock)
+			newerEntry.NumWantBlock += olderEntry.NumWantBlock
+			newerEntries[contentID] = newerEntry
+		}
+	}
+	return newer
+}
@@ -0,0 +1,285 @@
+package input
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/routing"
+	"ipfs-connect2all/helpers"
+	mrand "math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DHT lookups needed by the built-in crawler, e.g., provided by the WAN DHT of the running node; the lookups have
+// to publish routing.PeerResponse query events for the peers which answered (as go-libp2p-kad-dht does)
+type CrawlRouting interface {
+	GetClosestPeers(ctx context.Context, key string) (<-chan peer.ID, error)
+}
+
+// peer metadata needed by the built-in crawler, e.g., provided by the libp2p host of the running node
+type CrawlHost interface {
+	Peerstore() peerstore.Peerstore
+}
+
+type BuiltinCrawlConfig struct {
+	// directory for the visitedPeers output files (no trailing /)
+	OutDir string
+	// Go-style date format for the timestamps in the output file names
+	DateFormat string
+	// "random": look up random keys, "buckets": look up random keys in the buckets of already discovered peers
+	Mode string
+	// number of lookups per crawl
+	Queries int
+	// number of concurrent lookups
+	Concurrency int
+	// timeout for each lookup
+	Timeout time.Duration
+}
+
+// keys are only targeted at buckets up to this common prefix length (brute force: 2^(cpl+1) hashes)
+const builtinCrawlMaxCpl = 15
+
+// create built-in crawl config from the (string) config values of ipfs-connect2all
+func NewBuiltinCrawlConfig(configValues map[string]string) BuiltinCrawlConfig {
+	queries, err := strconv.Atoi(configValues["BuiltinCrawlQueries"])
+	if err != nil || queries < 1 {
+		queries = 256
+	}
+	concurrency, err := strconv.Atoi(configValues["BuiltinCrawlConcurrency"])
+	if err != nil || concurrency < 1 {
+		concurrency = 16
+	}
+	timeout, err := time.ParseDuration(configValues["BuiltinCrawlTimeout"])
+	if err != nil || timeout <= 0 {
+		timeout = time.Second * 30
+	}
+	mode := configValues["BuiltinCrawlMode"]
+	if mode != "buckets" {
+		mode = "random"
+	}
+	return BuiltinCrawlConfig{
+		OutDir:      configValues["DHTCrawlOut"],
+		DateFormat:  configValues["DateFormat"],
+		Mode:        mode,
+		Queries:     queries,
+		Concurrency: concurrency,
+		Timeout:     timeout,
+	}
+}
+
+// crawl the DHT with lookups of the running node and write a visitedPeers file in the format of ipfs-crawler
+// (without peer graph); peers are reachable if they answered a query of the lookups, no further connections
+// are made, addresses and agent versions are taken from the peerstore
+func CrawlDHTBuiltin(ctx context.Context, config BuiltinCrawlConfig, dht CrawlRouting,
+	host CrawlHost) (*CrawlResult, error) {
+
+	if err := helpers.CheckOrCreateDir(config.OutDir); err != nil {
+		return nil, errors.New("Could not access or create crawl output directory: " + err.Error())
+	}
+
+	startTime := time.Now()
+	discoveredMutex := &sync.Mutex{}
+	discovered := make(map[peer.ID]bool)
+	discoveredSlice := make([]peer.ID, 0)
+	// peers which answered a query
+	responded := make(map[peer.ID]bool)
+
+	discover := func(peerID peer.ID, hasResponded bool) {
+		discoveredMutex.Lock()
+		defer discoveredMutex.Unlock()
+		if !discovered[peerID] {
+			discovered[peerID] = true
+			discoveredSlice = append(discoveredSlice, peerID)
+		}
+		if hasResponded {
+			responded[peerID] = true
+		}
+	}
+
+	lookup := func(key string) {
+		lookupCtx, cancel := context.WithTimeout(ctx, config.Timeout)
+		eventCtx, events := routing.RegisterForQueryEvents(lookupCtx)
+		eventsDone := make(chan struct{})
+		go func() {
+			defer close(eventsDone)
+			for event := range events {
+				if event.Type != routing.PeerResponse {
+					continue
+				}
+				discover(event.ID, true)
+				for _, closerPeer := range event.Responses {
+					discover(closerPeer.ID, false)
+				}
+			}
+		}()
+		// the event channel is closed once the context is done
+		defer func() {
+			cancel()
+			<-eventsDone
+		}()
+
+		peerChan, err := dht.GetClosestPeers(eventCtx, key)
+		if err != nil {
+			return
+		}
+		for peerID := range peerChan {
+			discover(peerID, false)
+		}
+	}
+
+	nextKey := func() (string, error) {
+		if config.Mode == "buckets" {
+			discoveredMutex.Lock()
+			numDiscovered := len(discoveredSlice)
+			var target peer.ID
+			if numDiscovered > 0 {
+				target = discoveredSlice[mrand.Intn(numDiscovered)]
+			}
+			discoveredMutex.Unlock()
+			if numDiscovered > 0 {
+				return keyInBucket(target, mrand.Intn(builtinCrawlMaxCpl+1))
+			}
+		}
+		return randomKey()
+	}
+
+	keys := make(chan string)
+	var wg sync.WaitGroup
+	wg.Add(config.Concurrency)
+	for i := 0; i < config.Concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				lookup(key)
+			}
+		}()
+	}
+	var keyErr error
+	for i := 0; i < config.Queries && ctx.Err() == nil; i++ {
+		key, err := nextKey()
+		if err != nil {
+			keyErr = err
+			break
+		}
+		keys <- key
+	}
+	close(keys)
+	wg.Wait()
+	if keyErr != nil {
+		return nil, errors.New("Could not generate lookup key: " + keyErr.Error())
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	// reachability from the query responses, metadata from the peerstore
+	nodes := make(map[peer.ID]*VisitedPeer, len(discoveredSlice))
+	for _, peerID := range discoveredSlice {
+		agentVersion := ""
+		if av, err := host.Peerstore().Get(peerID, "AgentVersion"); err == nil {
+			agentVersion, _ = av.(string)
+		}
+		nodes[peerID] = &VisitedPeer{
+			NodeID:       peerID,
+			MultiAddrs:   host.Peerstore().Addrs(peerID),
+			Reachable:    responded[peerID],
+			AgentVersion: agentVersion,
+		}
+	}
+	endTime := time.Now()
+
+	ret := &CrawlResult{
+		StartDate: startTime.Format(config.DateFormat),
+		EndDate:   endTime.Format(config.DateFormat),
+		StartTime: startTime,
+		EndTime:   endTime,
+		Nodes:     nodes,
+	}
+	ret.VisitedPeersFile = config.OutDir + "/" +
+		fmt.Sprintf("visitedPeers_%s_%s.json", ret.StartDate, ret.EndDate)
+	err := WriteVisitedPeers(ret.VisitedPeersFile, ret)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// write visitedPeers*.json file in the format of ipfs-crawler
+func WriteVisitedPeers(visitedPeersFile string, result *CrawlResult) error {
+	jsonVisitedPeers := visitedPeers_json{
+		Start_timestamp: result.StartDate,
+		End_timestamp:   result.EndDate,
+		Nodes:           make([]visitedPeer_json, 0, len(result.Nodes)),
+	}
+	for _, node := range result.Nodes {
+		multiAddrs := make([]string, len(node.MultiAddrs))
+		for i, ma := range node.MultiAddrs {
+			multiAddrs[i] = ma.String()
+		}
+		jsonVisitedPeers.Nodes = append(jsonVisitedPeers.Nodes, visitedPeer_json{
+			NodeID:        node.NodeID.Pretty(),
+			MultiAddrs:    multiAddrs,
+			Reachable:     node.Reachable,
+			Agent_version: node.AgentVersion,
+		})
+	}
+
+	f, err := os.OpenFile(visitedPeersFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.New("Could not open visitedPeers file for writing: " + err.Error())
+	}
+	defer f.Close()
+	err = json.NewEncoder(f).Encode(jsonVisitedPeers)
+	if err != nil {
+		return errors.New("Could not write visitedPeers file: " + err.Error())
+	}
+	return f.Sync()
+}
+
+func randomKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// random key whose DHT ID (SHA-256) has exactly cpl leading bits in common with the DHT ID of the peer
+func keyInBucket(target peer.ID, cpl int) (string, error) {
+	targetHash := sha256.Sum256([]byte(target))
+	for {
+		key, err := randomKey()
+		if err != nil {
+			return "", err
+		}
+		keyHash := sha256.Sum256([]byte(key))
+		if commonPrefixLen(targetHash[:], keyHash[:]) == cpl {
+			return key, nil
+		}
+	}
+}
+
+func commonPrefixLen(a []byte, b []byte) int {
+	for i := range a {
+		x := a[i] ^ b[i]
+		if x == 0 {
+			continue
+		}
+		ret := i * 8
+		for x&0x80 == 0 {
+			ret++
+			x <<= 1
+		}
+		return ret
+	}
+	return len(a) * 8
+}
@@ -0,0 +1,128 @@
+package input
+
+import (
+	"context"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+type testDHTNode struct {
+	host host.Host
+	dht  *dht.IpfsDHT
+}
+
+func newTestDHTNode(t *testing.T, ctx context.Context) testDHTNode {
+	h, err := libp2p.New(ctx, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := dht.New(ctx, h, dht.Mode(dht.ModeServer), dht.DisableAutoRefresh())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testDHTNode{host: h, dht: d}
+}
+
+// in-process DHT network: a chain of nodes, each connected only to its predecessor, so that the crawling node (the
+// first one) can only find most nodes through the lookups
+func newTestDHTNetwork(t *testing.T, ctx context.Context, size int) []testDHTNode {
+	nodes := make([]testDHTNode, size)
+	for i := range nodes {
+		nodes[i] = newTestDHTNode(t, ctx)
+		if i == 0 {
+			continue
+		}
+		prev := nodes[i-1].host
+		err := nodes[i].host.Connect(ctx, peer.AddrInfo{ID: prev.ID(), Addrs: prev.Addrs()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// wait until the routing tables contain the neighbours
+	deadline := time.Now().Add(10 * time.Second)
+	for i := range nodes {
+		for nodes[i].dht.RoutingTable().Size() == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("routing tables not filled")
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	return nodes
+}
+
+func TestCrawlDHTBuiltin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	outDir, err := ioutil.TempDir("", "builtincrawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+
+	nodes := newTestDHTNetwork(t, ctx, 8)
+	defer func() {
+		for _, node := range nodes {
+			node.dht.Close()
+			node.host.Close()
+		}
+	}()
+	// stopped, i.e., unreachable; only in the routing table of the second node
+	unreachable := newTestDHTNode(t, ctx)
+	unreachableID, unreachableAddrs := unreachable.host.ID(), unreachable.host.Addrs()
+	unreachable.dht.Close()
+	unreachable.host.Close()
+	nodes[1].host.Peerstore().AddAddrs(unreachableID, unreachableAddrs, time.Hour)
+	if _, err := nodes[1].dht.RoutingTable().TryAddPeer(unreachableID, true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	config := BuiltinCrawlConfig{
+		OutDir:      outDir,
+		DateFormat:  "06-01-02--15:04:05",
+		Mode:        "buckets",
+		Queries:     16,
+		Concurrency: 4,
+		Timeout:     10 * time.Second,
+	}
+	result, err := CrawlDHTBuiltin(ctx, config, nodes[0].dht, nodes[0].host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, node := range nodes[1:] {
+		visitedPeer, ok := result.Nodes[node.host.ID()]
+		if !ok {
+			t.Errorf("peer %s not found", node.host.ID())
+			continue
+		}
+		if !visitedPeer.Reachable {
+			t.Errorf("peer %s not reachable", node.host.ID())
+		}
+		if len(visitedPeer.MultiAddrs) == 0 {
+			t.Errorf("no addresses for peer %s", node.host.ID())
+		}
+	}
+	if visitedPeer, ok := result.Nodes[unreachableID]; !ok {
+		t.Errorf("stopped peer %s not found", unreachableID)
+	} else if visitedPeer.Reachable {
+		t.Errorf("stopped peer %s reachable", unreachableID)
+	}
+
+	visitedPeers, err := LoadVisitedPeers(result.VisitedPeersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(visitedPeers) != len(result.Nodes) {
+		t.Errorf("visitedPeers file contains %d peers, expected %d", len(visitedPeers), len(result.Nodes))
+	}
+	if reachable := len(VisitedPeersToAddrInfoMap(visitedPeers)); reachable < len(nodes)-1 {
+		t.Errorf("visitedPeers file contains %d reachable peers, expected at least %d", reachable, len(nodes)-1)
+	}
+}
@@ -7,34 +7,79 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	"ipfs-connect2all/helpers"
 	"ipfs-crawler/crawling"
+	"os"
 	"strconv"
+	"time"
 )
 
-func CrawlDHT(configValues map[string]string, bootstrapPeers []*peer.AddrInfo) (map[peer.ID]*peer.AddrInfo, error) {
+type CrawlConfig struct {
+	// directory for the visitedPeers and peerGraph output files (no trailing /)
+	OutDir string
+	// Go-style date format for the timestamps in the output file names
+	DateFormat string
+	PreImagePath string
+	QueueSize int
+	// node cache file, empty to disable caching
+	CacheFile string
+}
 
-	if err := helpers.CheckOrCreateDir(configValues["DHTCrawlOut"]); err != nil {
+type CrawlResult struct {
+	// timestamps as formatted by the crawler (used in the output file names)
+	StartDate string
+	EndDate string
+	// parsed timestamps, zero if they could not be parsed
+	StartTime time.Time
+	EndTime time.Time
+	// all crawled nodes, including unreachable ones
+	Nodes map[peer.ID]*VisitedPeer
+	// empty if the file could not be written, see OutputErrors
+	VisitedPeersFile string
+	PeerGraphFile string
+	// output files of the crawl which could not be written (the crawl itself succeeded)
+	OutputErrors []string
+}
+
+// create crawl config from the (string) config values of ipfs-connect2all
+func NewCrawlConfig(configValues map[string]string) CrawlConfig {
+	queueSize, err := strconv.Atoi(configValues["DHTQueueSize"])
+	if err != nil {
+		queueSize = 64384
+	}
+	return CrawlConfig{
+		OutDir: configValues["DHTCrawlOut"],
+		DateFormat: configValues["DateFormat"],
+		PreImagePath: configValues["DHTPreImages"],
+		QueueSize: queueSize,
+		CacheFile: configValues["DHTCacheFile"],
+	}
+}
+
+// reachable nodes of the crawl
+func (r *CrawlResult) ReachableAddrInfos() map[peer.ID]*peer.AddrInfo {
+	return VisitedPeersToAddrInfoMap(r.Nodes)
+}
+
+func CrawlDHT(config CrawlConfig, bootstrapPeers []*peer.AddrInfo) (*CrawlResult, error) {
+
+	if err := helpers.CheckOrCreateDir(config.OutDir); err != nil {
 		return nil, errors.New("Could not access or create crawl output directory: " + err.Error())
 	}
 
 	crawlManagerConfig := crawling.ConfigureCrawlerManager()
-	crawlManagerConfig.FilenameTimeFormat = configValues["DateFormat"]
-	crawlManagerConfig.OutPath = configValues["DHTCrawlOut"] + "/"
+	crawlManagerConfig.FilenameTimeFormat = config.DateFormat
+	crawlManagerConfig.OutPath = config.OutDir + "/"
 
 	crawlWorkerConfig := crawling.Configure()
-	crawlWorkerConfig.PreImagePath = configValues["DHTPreImages"]
+	crawlWorkerConfig.PreImagePath = config.PreImagePath
 
-	queueSize, err := strconv.Atoi(configValues["DHTQueueSize"])
-	if err != nil {
-		queueSize = 64384
-	}
-	cm := crawling.NewCrawlManagerV2WithConfig(queueSize, crawlManagerConfig)
+	cm := crawling.NewCrawlManagerV2WithConfig(config.QueueSize, crawlManagerConfig)
 
 	worker := crawling.NewIPFSWorkerWithConfig(0, context.Background(), crawlWorkerConfig)
 	cm.AddWorker(worker)
 
 	var bootstrapPeersWithCache []*peer.AddrInfo
-	if configValues["DHTCacheFile"] != "" {
-		cachedNodes, err := crawling.RestoreNodeCache(configValues["DHTCacheFile"])
+	if config.CacheFile != "" {
+		cachedNodes, err := crawling.RestoreNodeCache(config.CacheFile)
 		if err == nil {
 			bootstrapPeersWithCache = make([]*peer.AddrInfo, len(bootstrapPeers), len(bootstrapPeers) + len(cachedNodes))
 			copy(bootstrapPeersWithCache, bootstrapPeers)
@@ -47,28 +92,65 @@ func CrawlDHT(configValues map[string]string, bootstrapPeers []*peer.AddrInfo) (
 	}
 
 	report := cm.CrawlNetwork(bootstrapPeersWithCache)
-	startStamp := report.StartDate
-	endStamp := report.EndDate
-	crawling.ReportToFile(report,
-		crawlManagerConfig.OutPath + fmt.Sprintf("visitedPeers_%s_%s.json", startStamp, endStamp))
-	crawling.WritePeergraph(report,
-		crawlManagerConfig.OutPath + fmt.Sprintf("peerGraph_%s_%s.csv", startStamp, endStamp))
-
-	if configValues["DHTCacheFile"] != "" {
-		crawling.SaveNodeCache(report, configValues["DHTCacheFile"])
+	ret := &CrawlResult{
+		StartDate: report.StartDate,
+		EndDate: report.EndDate,
+		Nodes: make(map[peer.ID]*VisitedPeer, len(report.Nodes)),
+		VisitedPeersFile: crawlManagerConfig.OutPath +
+			fmt.Sprintf("visitedPeers_%s_%s.json", report.StartDate, report.EndDate),
+		PeerGraphFile: crawlManagerConfig.OutPath +
+			fmt.Sprintf("peerGraph_%s_%s.csv", report.StartDate, report.EndDate),
+	}
+	if startTime, err := time.Parse(config.DateFormat, report.StartDate); err == nil {
+		ret.StartTime = startTime
+	}
+	if endTime, err := time.Parse(config.DateFormat, report.EndDate); err == nil {
+		ret.EndTime = endTime
 	}
 
-	ret := make(map[peer.ID]*peer.AddrInfo)
-	for rID, rNode := range report.Nodes {
-		if !rNode.Reachable {
-			continue
+	// the crawler does not report write errors, so check the files afterwards
+	writeTime := time.Now().Truncate(time.Second)
+	crawling.ReportToFile(report, ret.VisitedPeersFile)
+	if err := checkOutputFile(ret.VisitedPeersFile, writeTime); err != nil {
+		ret.OutputErrors = append(ret.OutputErrors, "visitedPeers file: "+err.Error())
+		ret.VisitedPeersFile = ""
+	}
+	crawling.WritePeergraph(report, ret.PeerGraphFile)
+	if err := checkOutputFile(ret.PeerGraphFile, writeTime); err != nil {
+		ret.OutputErrors = append(ret.OutputErrors, "peerGraph file: "+err.Error())
+		ret.PeerGraphFile = ""
+	}
+
+	if config.CacheFile != "" {
+		crawling.SaveNodeCache(report, config.CacheFile)
+		if err := checkOutputFile(config.CacheFile, writeTime); err != nil {
+			ret.OutputErrors = append(ret.OutputErrors, "node cache file: "+err.Error())
 		}
-		addrInfo := &peer.AddrInfo{
-			ID: rID,
-			Addrs: rNode.MultiAddrs,
+	}
+
+	for rID, rNode := range report.Nodes {
+		ret.Nodes[rID] = &VisitedPeer{
+			NodeID: rID,
+			MultiAddrs: rNode.MultiAddrs,
+			Reachable: rNode.Reachable,
+			AgentVersion: rNode.AgentVersion,
 		}
-		ret[rID] = addrInfo
 	}
 
 	return ret, nil
-}
\ No newline at end of file
+}
+
+// check that an output file exists, is not empty and has been written at or after writeTime
+func checkOutputFile(filename string, writeTime time.Time) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return errors.New(filename + " is empty")
+	}
+	if info.ModTime().Before(writeTime) {
+		return errors.New(filename + " has not been written")
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package input
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outcome of the DHT crawls of a run, safe for concurrent use
+type CrawlStatus struct {
+	mutex               *sync.Mutex
+	successful          int
+	failed              int
+	consecutiveFailures int
+	lastError           string
+	lastErrorTime       time.Time
+	lastSuccessTime     time.Time
+	lastVisitedPeers    string
+	// successful crawls with output files which could not be written
+	outputFailures  int
+	lastOutputError string
+	// called with the result of each successful crawl (e.g., to store it in the database)
+	resultCallback func(*CrawlResult)
+}
+
+type CrawlPolicy struct {
+	// number of retries after a failed crawl before waiting for the next crawl interval
+	Retries    int
+	RetryDelay time.Duration
+}
+
+func NewCrawlStatus() *CrawlStatus {
+	return &CrawlStatus{mutex: &sync.Mutex{}}
+}
+
+func NewCrawlStatusWithCallback(resultCallback func(*CrawlResult)) *CrawlStatus {
+	ret := NewCrawlStatus()
+	ret.resultCallback = resultCallback
+	return ret
+}
+
+func (s *CrawlStatus) RecordSuccess(result *CrawlResult) {
+	s.mutex.Lock()
+	s.successful++
+	s.consecutiveFailures = 0
+	s.lastSuccessTime = time.Now()
+	s.lastVisitedPeers = result.VisitedPeersFile
+	if len(result.OutputErrors) > 0 {
+		s.outputFailures++
+		s.lastOutputError = strings.Join(result.OutputErrors, "; ")
+	}
+	s.mutex.Unlock()
+
+	for _, outputError := range result.OutputErrors {
+		log.Printf("Could not write DHT crawl output: %s", outputError)
+	}
+
+	if s.resultCallback != nil {
+		s.resultCallback(result)
+	}
+}
+
+func (s *CrawlStatus) RecordFailure(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failed++
+	s.consecutiveFailures++
+	s.lastError = err.Error()
+	s.lastErrorTime = time.Now()
+}
+
+// number of successful and failed crawl attempts
+func (s *CrawlStatus) Counts() (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.successful, s.failed
+}
+
+func (s *CrawlStatus) WriteToFile(filename string) error {
+	s.mutex.Lock()
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	content, err := json.MarshalIndent(struct {
+		Successful          int
+		Failed              int
+		ConsecutiveFailures int
+		LastError           string
+		LastErrorTime       string
+		LastSuccessTime     string
+		LastVisitedPeers    string
+		OutputFailures      int
+		LastOutputError     string
+	}{
+		Successful:          s.successful,
+		Failed:              s.failed,
+		ConsecutiveFailures: s.consecutiveFailures,
+		LastError:           s.lastError,
+		LastErrorTime:       formatTime(s.lastErrorTime),
+		LastSuccessTime:     formatTime(s.lastSuccessTime),
+		LastVisitedPeers:    s.lastVisitedPeers,
+		OutputFailures:      s.outputFailures,
+		LastOutputError:     s.lastOutputError,
+	}, "", "  ")
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(content, '\n'))
+	return err
+}
+
+// crawl the DHT, retrying according to the policy; errors (and panics of the crawler) are logged and recorded
+func CrawlDHTWithRetries(config CrawlConfig, bootstrapPeers []*peer.AddrInfo, policy CrawlPolicy,
+	status *CrawlStatus) (*CrawlResult, error) {
+	return CrawlWithRetries(func() (*CrawlResult, error) {
+		return CrawlDHT(config, bootstrapPeers)
+	}, policy, status)
+}
+
+// run any crawl function, retrying according to the policy; errors (and panics) are logged and recorded; only
+// panics in the goroutine calling crawl are recovered, panics in goroutines started by the crawler (e.g., the
+// workers of ipfs-crawler) still terminate the process
+func CrawlWithRetries(crawl func() (*CrawlResult, error), policy CrawlPolicy, status *CrawlStatus) (*CrawlResult, error) {
+	var err error
+	for attempt := 0; attempt <= policy.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(policy.RetryDelay)
+		}
+		var result *CrawlResult
+		result, err = crawlRecovered(crawl)
+		if err == nil {
+			status.RecordSuccess(result)
+			return result, nil
+		}
+		status.RecordFailure(err)
+		log.Printf("DHT crawl failed (attempt %d of %d): %s", attempt+1, policy.Retries+1, err)
+	}
+	return nil, err
+}
+
+func crawlRecovered(crawl func() (*CrawlResult, error)) (result *CrawlResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			err = fmt.Errorf("crawler panicked: %v", r)
+		}
+	}()
+	return crawl()
+}
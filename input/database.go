@@ -0,0 +1,57 @@
+package input
+
+import (
+	"errors"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"ipfs-connect2all/database"
+)
+
+// crawl record for the database
+func (r *CrawlResult) DatabaseRecord() *database.Crawl {
+	ret := &database.Crawl{
+		StartTime:        r.StartTime,
+		EndTime:          r.EndTime,
+		VisitedPeersFile: r.VisitedPeersFile,
+		Nodes:            make([]database.CrawlNode, 0, len(r.Nodes)),
+	}
+	for peerID, visitedPeer := range r.Nodes {
+		addrs := make([]string, len(visitedPeer.MultiAddrs))
+		for i, addr := range visitedPeer.MultiAddrs {
+			addrs[i] = addr.String()
+		}
+		ret.Nodes = append(ret.Nodes, database.CrawlNode{
+			PeerID:       peerID.String(),
+			Addrs:        addrs,
+			Reachable:    visitedPeer.Reachable,
+			AgentVersion: visitedPeer.AgentVersion,
+		})
+	}
+	return ret
+}
+
+// visited peers of a crawl from the database, like LoadVisitedPeers
+func DatabaseVisitedPeers(crawl *database.Crawl) (map[peer.ID]*VisitedPeer, error) {
+	ret := make(map[peer.ID]*VisitedPeer, len(crawl.Nodes))
+	for _, node := range crawl.Nodes {
+		id, err := peer.Decode(node.PeerID)
+		if err != nil {
+			return nil, errors.New("Could not decode peer ID from database: " + err.Error())
+		}
+		multiaddrs := make([]multiaddr.Multiaddr, 0, len(node.Addrs))
+		for _, addr := range node.Addrs {
+			newMa, err := multiaddr.NewMultiaddr(addr)
+			if err != nil {
+				return nil, errors.New("Could not decode multiaddr from database: " + err.Error())
+			}
+			multiaddrs = append(multiaddrs, newMa)
+		}
+		ret[id] = &VisitedPeer{
+			NodeID:       id,
+			MultiAddrs:   multiaddrs,
+			Reachable:    node.Reachable,
+			AgentVersion: node.AgentVersion,
+		}
+	}
+	return ret, nil
+}
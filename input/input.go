@@ -10,9 +10,11 @@ import (
 	"github.com/libp2p/go-libp2p-core/protocol"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/prometheus/common/log"
+	"ipfs-connect2all/annotation"
 	"ipfs-connect2all/helpers"
-	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type VisitedPeer struct {
@@ -35,18 +37,37 @@ type visitedPeer_json struct {
 	Agent_version string
 }
 
+// directed neighbourship graph from a peerGraph_*.csv file of an ipfs-crawler run
+type PeerGraph struct {
+	// neighbours returned by each crawled peer
+	Neighbours map[peer.ID][]peer.ID
+	// reachability of the crawled peers (sources of edges)
+	Online map[peer.ID]bool
+}
+
 type ConnectedPeer struct {
 	NodeID peer.ID
 	Direction network.Direction
 	SupportedProtocols []protocol.ID
+	// remote multiaddr of the connection, nil if unknown (like the following fields, not in older snapshots)
+	Addr multiaddr.Multiaddr
+	Transport string
+	// age of the connection at the time of the snapshot, -1 if unknown
+	Age time.Duration
+	AgentVersion string
+	// 0 if unknown
+	Latency time.Duration
+	// number of open streams, -1 if unknown
+	Streams int
 }
 
 // load visitedPeers*.json file from an ipfs-crawler run
 func LoadVisitedPeers(visitedPeersFile string) (map[peer.ID]*VisitedPeer, error) {
-	f, err := os.Open(visitedPeersFile)
+	f, err := helpers.OpenDecompressed(visitedPeersFile)
 	if err != nil {
 		return nil, errors.New("Could not open visitedPeers file for reading: " + err.Error())
 	}
+	defer f.Close()
 
 	r := json.NewDecoder(f)
 	var jsonVisitedPeers visitedPeers_json
@@ -79,51 +100,157 @@ func LoadVisitedPeers(visitedPeersFile string) (map[peer.ID]*VisitedPeer, error)
 	return ret, nil
 }
 
+// load peerGraph*.csv file from an ipfs-crawler run (rows: source;target[;online[;...]], header optional)
+func LoadPeerGraph(peerGraphFile string) (*PeerGraph, error) {
+	f, err := helpers.OpenDecompressed(peerGraphFile)
+	if err != nil {
+		return nil, errors.New("Could not open peer graph file for reading: " + err.Error())
+	}
+	defer f.Close()
+
+	ret := &PeerGraph{
+		Neighbours: make(map[peer.ID][]peer.ID),
+		Online: make(map[peer.ID]bool),
+	}
+	scn := bufio.NewScanner(f)
+	scn.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	firstRow := true
+	for scn.Scan() {
+		st := strings.TrimSpace(scn.Text())
+		if len(st) < 1 {
+			continue
+		}
+		separator := ";"
+		if strings.IndexByte(st, ';') < 0 {
+			separator = ","
+		}
+		row := strings.Split(st, separator)
+		if len(row) < 2 {
+			return nil, errors.New("Invalid row length in peer graph file (should be at least 2)")
+		}
+		source, err := peer.Decode(row[0])
+		if err != nil {
+			if firstRow {
+				// header
+				firstRow = false
+				continue
+			}
+			return nil, errors.New("Could not decode peer ID from peer graph file: " + err.Error())
+		}
+		firstRow = false
+		target, err := peer.Decode(row[1])
+		if err != nil {
+			return nil, errors.New("Could not decode peer ID from peer graph file: " + err.Error())
+		}
+		ret.Neighbours[source] = append(ret.Neighbours[source], target)
+		if len(row) > 2 {
+			online, err := strconv.ParseBool(row[2])
+			if err == nil {
+				ret.Online[source] = online
+			}
+		}
+	}
+	if err := scn.Err(); err != nil {
+		return nil, errors.New("Could not read peer graph file: " + err.Error())
+	}
+	return ret, nil
+}
+
 // load connected peers from snapshot (connected_*.csv file)
 func LoadConnectedPeers(connectedPeersFile string) (map[peer.ID]*ConnectedPeer, error) {
-	f, err := os.Open(connectedPeersFile)
+	f, err := helpers.OpenDecompressed(connectedPeersFile)
 	if err != nil {
 		return nil, errors.New("Could not open connected peers file for reading: " + err.Error())
 	}
+	defer f.Close()
+
 	r := csv.NewReader(f)
 	r.Comma = ';'
+	r.FieldsPerRecord = -1
 	row, err := r.Read()
 	ret := make(map[peer.ID]*ConnectedPeer)
 	for ; err == nil; row, err = r.Read() {
-		if len(row) < 3 {
-			return ret, errors.New("Invalid CSV row length in connected peers file (should be at least 3)")
-		}
-
 		id, err := peer.Decode(row[0])
 		if err != nil {
 			return nil, errors.New("Could not decode peer ID from connected peers file: " + err.Error())
 		}
 
-		direction, err := strconv.Atoi(row[1])
-		if err != nil {
-			return nil, errors.New("Could not read direction from connected peers file: " + err.Error())
-		}
-
 		ret[id] = &ConnectedPeer{
 			NodeID: id,
-			Direction: network.Direction(direction),
-			SupportedProtocols: helpers.SupportedProtocolsFromString(row[2]),
+			Direction: network.DirUnknown,
+			Age: -1,
+			Streams: -1,
+		}
+		// the oldest snapshots only contain the peer ID
+		if len(row) >= 3 {
+			direction, err := strconv.Atoi(row[1])
+			if err != nil {
+				return nil, errors.New("Could not read direction from connected peers file: " + err.Error())
+			}
+			ret[id].Direction = network.Direction(direction)
+			ret[id].SupportedProtocols = helpers.SupportedProtocolsFromString(row[2])
+		}
+		// not in older snapshots
+		if len(row) >= 9 {
+			err = ret[id].setDetails(row[3:9])
+			if err != nil {
+				return nil, errors.New("Could not read connected peers file: " + err.Error())
+			}
 		}
 	}
 	return ret, nil
 }
 
+// columns after the stream protocols in connected peers snapshots (see helpers.TransformConnInfoSliceForCsv)
+func (c *ConnectedPeer) setDetails(columns []string) error {
+	var err error
+	if columns[0] != "" {
+		c.Addr, err = multiaddr.NewMultiaddr(columns[0])
+		if err != nil {
+			return errors.New("Could not decode multiaddr: " + err.Error())
+		}
+	}
+	c.Transport = columns[1]
+	if columns[2] != "" {
+		age, err := strconv.ParseInt(columns[2], 10, 64)
+		if err != nil {
+			return errors.New("Could not read connection age: " + err.Error())
+		}
+		c.Age = time.Duration(age) * time.Second
+	}
+	c.AgentVersion = columns[3]
+	if columns[4] != "" {
+		latency, err := strconv.ParseFloat(columns[4], 64)
+		if err != nil {
+			return errors.New("Could not read latency: " + err.Error())
+		}
+		c.Latency = time.Duration(latency * float64(time.Millisecond))
+	}
+	if columns[5] != "" {
+		c.Streams, err = strconv.Atoi(columns[5])
+		if err != nil {
+			return errors.New("Could not read number of streams: " + err.Error())
+		}
+	}
+	return nil
+}
+
 // load peer list from other snapshot files
 func LoadPeerList(peerListFile string) (map[peer.ID]peer.ID, error) {
-	f, err := os.Open(peerListFile)
+	f, err := helpers.OpenDecompressed(peerListFile)
 	if err != nil {
 		return nil, errors.New("Could not open peer list file for reading: " + err.Error())
 	}
+	defer f.Close()
 
 	scn := bufio.NewScanner(f)
 	ret := make(map[peer.ID]peer.ID)
 	for scn.Scan() {
 		st := scn.Text()
+		// additional columns (e.g., annotations) are ignored
+		if sepPos := strings.IndexByte(st, ';'); sepPos >= 0 {
+			st = st[:sepPos]
+		}
 		if len(st) < 1 {
 			continue
 		}
@@ -136,6 +263,95 @@ func LoadPeerList(peerListFile string) (map[peer.ID]peer.ID, error) {
 	return ret, nil
 }
 
+// load country and ASN annotations from the columns after the peer ID in a snapshot file (if present)
+func LoadPeerAnnotations(peerListFile string) (map[peer.ID]annotation.Annotation, error) {
+	f, err := helpers.OpenDecompressed(peerListFile)
+	if err != nil {
+		return nil, errors.New("Could not open peer list file for reading: " + err.Error())
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = ';'
+	r.FieldsPerRecord = -1
+	ret := make(map[peer.ID]annotation.Annotation)
+	row, err := r.Read()
+	for ; err == nil; row, err = r.Read() {
+		if len(row) < 3 {
+			continue
+		}
+		// empty if only addresses have been recorded
+		if row[1] == "" {
+			continue
+		}
+		id, err := peer.Decode(row[0])
+		if err != nil {
+			return nil, errors.New("Could not decode peer ID from peer list file: " + err.Error())
+		}
+		peerAnnotation, err := annotation.FromCsvColumns(row[1:3])
+		if err != nil {
+			return nil, errors.New("Could not read annotation from peer list file: " + err.Error())
+		}
+		ret[id] = peerAnnotation
+	}
+	return ret, nil
+}
+
+// load the address columns after the country and ASN columns in a known peers snapshot file (if present)
+func LoadKnownPeerAddrs(knownPeersFile string) (map[peer.ID]helpers.AddrSummary, error) {
+	f, err := helpers.OpenDecompressed(knownPeersFile)
+	if err != nil {
+		return nil, errors.New("Could not open known peers file for reading: " + err.Error())
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = ';'
+	r.FieldsPerRecord = -1
+	ret := make(map[peer.ID]helpers.AddrSummary)
+	row, err := r.Read()
+	for ; err == nil; row, err = r.Read() {
+		if len(row) < 10 {
+			continue
+		}
+		id, err := peer.Decode(row[0])
+		if err != nil {
+			return nil, errors.New("Could not decode peer ID from known peers file: " + err.Error())
+		}
+		addrSummary, err := helpers.AddrSummaryFromCsvColumns(row[3:10])
+		if err != nil {
+			return nil, errors.New("Could not read addresses from known peers file: " + err.Error())
+		}
+		ret[id] = addrSummary
+	}
+	return ret, nil
+}
+
+// load peer sources of connection attempts from snapshot (sources_*.csv file)
+func LoadPeerSources(peerSourcesFile string) (map[peer.ID]string, error) {
+	f, err := helpers.OpenDecompressed(peerSourcesFile)
+	if err != nil {
+		return nil, errors.New("Could not open peer sources file for reading: " + err.Error())
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = ';'
+	ret := make(map[peer.ID]string)
+	row, err := r.Read()
+	for ; err == nil; row, err = r.Read() {
+		if len(row) < 2 {
+			return ret, errors.New("Invalid CSV row length in peer sources file (should be at least 2)")
+		}
+		id, err := peer.Decode(row[0])
+		if err != nil {
+			return nil, errors.New("Could not decode peer ID from peer sources file: " + err.Error())
+		}
+		ret[id] = row[1]
+	}
+	return ret, nil
+}
+
 // convert map from VisitedPeer map to peer.AddrInfo map, skipping unreachable peers
 func VisitedPeersToAddrInfoMap(visitedPeers map[peer.ID]*VisitedPeer) map[peer.ID]*peer.AddrInfo {
 	ret := make(map[peer.ID]*peer.AddrInfo)
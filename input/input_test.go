@@ -0,0 +1,133 @@
+package input
+
+import (
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	"io/ioutil"
+	"ipfs-connect2all/helpers"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testConnectionInfo struct {
+	id        peer.ID
+	addr      multiaddr.Multiaddr
+	direction network.Direction
+	latency   time.Duration
+	streams   []protocol.ID
+}
+
+func (c testConnectionInfo) ID() peer.ID                     { return c.id }
+func (c testConnectionInfo) Address() multiaddr.Multiaddr    { return c.addr }
+func (c testConnectionInfo) Direction() network.Direction    { return c.direction }
+func (c testConnectionInfo) Latency() (time.Duration, error) { return c.latency, nil }
+func (c testConnectionInfo) Streams() ([]protocol.ID, error) { return c.streams, nil }
+
+func testPeerID(t *testing.T, s string) peer.ID {
+	id, err := peer.Decode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// connected peers written like the snapshots and read back from the connected_ file and from a snapshot document
+func loadConnectedPeersRoundTrip(t *testing.T, dir string, name string, rows [][]string,
+	compression string) []map[peer.ID]*ConnectedPeer {
+	filename := filepath.Join(dir, name)
+	if err := helpers.WriteCompressedCsvFile(filename, rows, compression); err != nil {
+		t.Fatal(err)
+	}
+	fromFile, err := LoadConnectedPeers(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, err := helpers.NewSnapshotDocumentFromRows(time.Now(), map[string][][]string{"connected": rows}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromDocument, err := DocumentConnectedPeers(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []map[peer.ID]*ConnectedPeer{fromFile, fromDocument}
+}
+
+func TestLoadConnectedPeers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inbound := testPeerID(t, "QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN")
+	outbound := testPeerID(t, "QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa")
+	addr, err := multiaddr.NewMultiaddr("/ip4/147.75.69.143/tcp/4001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connInfos := []iface.ConnectionInfo{
+		testConnectionInfo{id: inbound, addr: addr, direction: network.DirInbound, latency: 1500 * time.Microsecond,
+			streams: []protocol.ID{"/ipfs/kad/1.0.0", "/ipfs/bitswap/1.2.0"}},
+		testConnectionInfo{id: outbound, direction: network.DirOutbound},
+	}
+	rows := helpers.TransformConnInfoSliceForCsv(connInfos, nil, time.Now())
+
+	for _, compression := range []string{helpers.CompressionNone, helpers.CompressionGzip} {
+		for _, connectedPeers := range loadConnectedPeersRoundTrip(t, dir, "connected_"+compression+".csv", rows,
+			compression) {
+			if len(connectedPeers) != 2 {
+				t.Fatalf("%s: %d connected peers, expected 2", compression, len(connectedPeers))
+			}
+			c := connectedPeers[inbound]
+			if c == nil || c.Direction != network.DirInbound || len(c.SupportedProtocols) != 2 ||
+				c.SupportedProtocols[1] != "/ipfs/bitswap/1.2.0" {
+				t.Fatalf("%s: unexpected connected peer: %+v", compression, c)
+			}
+			if c.Addr == nil || !c.Addr.Equal(addr) || c.Transport != "tcp" || c.Latency != 1500*time.Microsecond {
+				t.Errorf("%s: unexpected connection details: %+v", compression, c)
+			}
+			// not available without the host
+			if c.Age != -1 || c.Streams != -1 || c.AgentVersion != "" {
+				t.Errorf("%s: unexpected host details: %+v", compression, c)
+			}
+			c = connectedPeers[outbound]
+			if c == nil || c.Direction != network.DirOutbound || c.Addr != nil || c.Latency != 0 {
+				t.Errorf("%s: unexpected connected peer: %+v", compression, c)
+			}
+		}
+	}
+
+	// older snapshots: peer ID, direction and stream protocols, or only the peer ID
+	legacyRows := [][]string{
+		{inbound.String(), "1", "/ipfs/kad/1.0.0"},
+	}
+	for _, connectedPeers := range loadConnectedPeersRoundTrip(t, dir, "connected_3.csv", legacyRows,
+		helpers.CompressionNone) {
+		c := connectedPeers[inbound]
+		if c == nil || c.Direction != network.DirInbound || len(c.SupportedProtocols) != 1 || c.Addr != nil ||
+			c.Age != -1 || c.Streams != -1 {
+			t.Errorf("unexpected connected peer from 3-column file: %+v", c)
+		}
+	}
+	legacyRows = [][]string{
+		{inbound.String()},
+		{outbound.String()},
+	}
+	for _, connectedPeers := range loadConnectedPeersRoundTrip(t, dir, "connected_1.csv", legacyRows,
+		helpers.CompressionNone) {
+		if len(connectedPeers) != 2 {
+			t.Fatalf("%d connected peers from 1-column file, expected 2", len(connectedPeers))
+		}
+		for id, c := range connectedPeers {
+			if c.NodeID != id || c.Direction != network.DirUnknown || len(c.SupportedProtocols) != 0 {
+				t.Errorf("unexpected connected peer from 1-column file: %+v", c)
+			}
+		}
+	}
+}
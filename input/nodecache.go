@@ -0,0 +1,62 @@
+package input
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"ipfs-crawler/crawling"
+	"os"
+)
+
+// load node cache file of ipfs-crawler (DHTCacheFile)
+func LoadNodeCache(cacheFile string) ([]*peer.AddrInfo, error) {
+	nodes, err := crawling.RestoreNodeCache(cacheFile)
+	if err != nil {
+		return nil, errors.New("Could not load node cache: " + err.Error())
+	}
+	return nodes, nil
+}
+
+// write node cache file which can be restored by ipfs-crawler (JSON list of peer.AddrInfo)
+func WriteNodeCache(cacheFile string, nodes []*peer.AddrInfo) error {
+	content, err := json.Marshal(nodes)
+	if err != nil {
+		return errors.New("Could not encode node cache: " + err.Error())
+	}
+	f, err := os.OpenFile(cacheFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.New("Could not open node cache file for writing: " + err.Error())
+	}
+	defer f.Close()
+	_, err = f.Write(content)
+	if err != nil {
+		return errors.New("Could not write node cache file: " + err.Error())
+	}
+	return f.Sync()
+}
+
+// merge node caches, the addresses of peers contained in several caches are combined
+func MergeNodeCaches(caches ...[]*peer.AddrInfo) []*peer.AddrInfo {
+	ret := make([]*peer.AddrInfo, 0)
+	byID := make(map[peer.ID]*peer.AddrInfo)
+	seenAddrs := make(map[peer.ID]map[string]bool)
+	for _, cache := range caches {
+		for _, node := range cache {
+			merged, exists := byID[node.ID]
+			if !exists {
+				merged = &peer.AddrInfo{ID: node.ID, Addrs: make([]multiaddr.Multiaddr, 0, len(node.Addrs))}
+				byID[node.ID] = merged
+				seenAddrs[node.ID] = make(map[string]bool)
+				ret = append(ret, merged)
+			}
+			for _, addr := range node.Addrs {
+				if !seenAddrs[node.ID][addr.String()] {
+					seenAddrs[node.ID][addr.String()] = true
+					merged.Addrs = append(merged.Addrs, addr)
+				}
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,94 @@
+package input
+
+import (
+	"errors"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"sync"
+)
+
+// allowlist and denylist of peers, loaded from files in the format of the peer list snapshot files
+type PeerFilter struct {
+	allowlistFile string
+	denylistFile  string
+	mutex         *sync.RWMutex
+	allowlist     map[peer.ID]peer.ID
+	denylist      map[peer.ID]peer.ID
+}
+
+// create peer filter and load the lists (empty filename: list not used)
+func NewPeerFilter(allowlistFile string, denylistFile string) (*PeerFilter, error) {
+	ret := &PeerFilter{
+		allowlistFile: allowlistFile,
+		denylistFile:  denylistFile,
+		mutex:         &sync.RWMutex{},
+	}
+	err := ret.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// reload the lists from their files, keeping the old lists if an error occurs
+func (f *PeerFilter) Reload() error {
+	var allowlist, denylist map[peer.ID]peer.ID
+	var err error
+	if f.allowlistFile != "" {
+		allowlist, err = LoadPeerList(f.allowlistFile)
+		if err != nil {
+			return errors.New("Could not load peer allowlist: " + err.Error())
+		}
+	}
+	if f.denylistFile != "" {
+		denylist, err = LoadPeerList(f.denylistFile)
+		if err != nil {
+			return errors.New("Could not load peer denylist: " + err.Error())
+		}
+	}
+	f.mutex.Lock()
+	f.allowlist = allowlist
+	f.denylist = denylist
+	f.mutex.Unlock()
+	return nil
+}
+
+// returns true if an allowlist or denylist is used
+func (f *PeerFilter) IsActive() bool {
+	return f.allowlistFile != "" || f.denylistFile != ""
+}
+
+// returns true if the peer is in the allowlist (if used) and not in the denylist
+func (f *PeerFilter) IsAllowed(peerID peer.ID) bool {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	if f.allowlist != nil {
+		if _, allowed := f.allowlist[peerID]; !allowed {
+			return false
+		}
+	}
+	if f.denylist != nil {
+		if _, denied := f.denylist[peerID]; denied {
+			return false
+		}
+	}
+	return true
+}
+
+// returns true if the peer is in the denylist (if used)
+func (f *PeerFilter) IsDenied(peerID peer.ID) bool {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	_, denied := f.denylist[peerID]
+	return denied
+}
+
+// copy of the map containing only the allowed peers
+func (f *PeerFilter) FilterBoolMap(in map[peer.ID]bool) map[peer.ID]bool {
+	out := make(map[peer.ID]bool, len(in))
+	for peerID, v := range in {
+		if f.IsAllowed(peerID) {
+			out[peerID] = v
+		}
+	}
+	return out
+}
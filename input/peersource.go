@@ -0,0 +1,129 @@
+package input
+
+import (
+	"context"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"log"
+	"sync"
+	"time"
+)
+
+// source of peers for the dialer of ipfs-connect2all
+type PeerSource interface {
+	// name of the source, used to tag the peers it contributed
+	Name() string
+	// maximum number of peers passed to the dialer per second, 0 for no limit
+	PeersPerSecond() int
+	// send peers to the channel until the source is exhausted or ctx is done, called once
+	Run(ctx context.Context, peers chan<- peer.AddrInfo) error
+}
+
+// peer sources implementing this interface and returning true bypass the peer and dial filters
+// (e.g., bootstrap peers with UnfilteredBootstrap, which may be needed to join the network at all)
+type UnfilteredPeerSource interface {
+	Unfiltered() bool
+}
+
+// everything a peer source factory may need besides the config values
+type PeerSourceEnv struct {
+	ConfigValues   map[string]string
+	BootstrapPeers map[peer.ID]*peer.AddrInfo
+	CrawlStatus    *CrawlStatus
+	// DHT and host of the running node, for the built-in crawler
+	CrawlRouting CrawlRouting
+	CrawlHost    CrawlHost
+}
+
+// create peer source from config, returns nil if the source is not enabled
+type PeerSourceFactory func(env PeerSourceEnv) (PeerSource, error)
+
+type peerSourceRegistration struct {
+	name    string
+	factory PeerSourceFactory
+}
+
+var peerSourceRegistryMutex = &sync.Mutex{}
+var peerSourceRegistry = make([]peerSourceRegistration, 0)
+
+// register a peer source factory, usually called in init()
+func RegisterPeerSource(name string, factory PeerSourceFactory) {
+	peerSourceRegistryMutex.Lock()
+	defer peerSourceRegistryMutex.Unlock()
+	peerSourceRegistry = append(peerSourceRegistry, peerSourceRegistration{name: name, factory: factory})
+}
+
+// names of all registered peer sources, in order of registration
+func RegisteredPeerSources() []string {
+	peerSourceRegistryMutex.Lock()
+	defer peerSourceRegistryMutex.Unlock()
+	ret := make([]string, len(peerSourceRegistry))
+	for i, registration := range peerSourceRegistry {
+		ret[i] = registration.name
+	}
+	return ret
+}
+
+// create all enabled peer sources
+func NewPeerSources(env PeerSourceEnv) ([]PeerSource, error) {
+	peerSourceRegistryMutex.Lock()
+	registrations := append([]peerSourceRegistration{}, peerSourceRegistry...)
+	peerSourceRegistryMutex.Unlock()
+
+	ret := make([]PeerSource, 0, len(registrations))
+	for _, registration := range registrations {
+		source, err := registration.factory(env)
+		if err != nil {
+			return nil, err
+		}
+		if source != nil {
+			ret = append(ret, source)
+		}
+	}
+	return ret, nil
+}
+
+func IsUnfilteredPeerSource(source PeerSource) bool {
+	unfilteredSource, ok := source.(UnfilteredPeerSource)
+	return ok && unfilteredSource.Unfiltered()
+}
+
+// run all peer sources in the background and call dial for each peer, respecting the rate limits of the sources
+func RunPeerSources(ctx context.Context, sources []PeerSource, dial func(peerInfo peer.AddrInfo, source PeerSource)) {
+	for _, source := range sources {
+		go func(source PeerSource) {
+			peers := make(chan peer.AddrInfo)
+			go func() {
+				defer close(peers)
+				err := source.Run(ctx, peers)
+				if err != nil {
+					log.Printf("Peer source %s stopped with error: %s", source.Name(), err)
+				}
+			}()
+
+			peersPerSecond := source.PeersPerSecond()
+			peersLeft := peersPerSecond
+			for peerInfo := range peers {
+				if peersPerSecond > 0 {
+					if peersLeft < 1 {
+						time.Sleep(time.Second)
+						peersLeft = peersPerSecond
+					}
+					peersLeft--
+				}
+				go dial(peerInfo, source)
+			}
+		}(source)
+	}
+}
+
+// send peers to the channel, returns false if ctx is done
+func sendPeers(ctx context.Context, peers chan<- peer.AddrInfo, peerInfos map[peer.ID]*peer.AddrInfo) bool {
+	for _, peerInfo := range peerInfos {
+		select {
+		case peers <- *peerInfo:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
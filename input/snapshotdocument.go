@@ -0,0 +1,150 @@
+package input
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	"ipfs-connect2all/annotation"
+	"ipfs-connect2all/helpers"
+	"time"
+)
+
+// load snapshot_*.json document (gzip- or zstd-compressed or not)
+func LoadSnapshotDocument(snapshotFile string) (*helpers.SnapshotDocument, error) {
+	f, err := helpers.OpenDecompressed(snapshotFile)
+	if err != nil {
+		return nil, errors.New("Could not open snapshot document for reading: " + err.Error())
+	}
+	defer f.Close()
+
+	var ret helpers.SnapshotDocument
+	err = json.NewDecoder(f).Decode(&ret)
+	if err != nil {
+		return nil, errors.New("JSON decode error: " + err.Error())
+	}
+	if ret.Version > helpers.SnapshotDocumentVersion {
+		return nil, fmt.Errorf("Unsupported snapshot document version %d", ret.Version)
+	}
+	return &ret, nil
+}
+
+// peer list of a snapshot document (e.g., Established), like LoadPeerList
+func DocumentPeerList(peerIDs []string) (map[peer.ID]peer.ID, error) {
+	ret := make(map[peer.ID]peer.ID, len(peerIDs))
+	for _, peerIDString := range peerIDs {
+		id, err := peer.Decode(peerIDString)
+		if err != nil {
+			return nil, errors.New("Could not decode peer ID from snapshot document: " + err.Error())
+		}
+		ret[id] = id
+	}
+	return ret, nil
+}
+
+// known peers of a snapshot document, like LoadPeerList
+func DocumentKnownPeers(document *helpers.SnapshotDocument) (map[peer.ID]peer.ID, error) {
+	peerIDs := make([]string, len(document.Known))
+	for i, knownPeer := range document.Known {
+		peerIDs[i] = knownPeer.PeerID
+	}
+	return DocumentPeerList(peerIDs)
+}
+
+// connected peers of a snapshot document, like LoadConnectedPeers
+func DocumentConnectedPeers(document *helpers.SnapshotDocument) (map[peer.ID]*ConnectedPeer, error) {
+	ret := make(map[peer.ID]*ConnectedPeer, len(document.Connected))
+	for _, connectedPeer := range document.Connected {
+		id, err := peer.Decode(connectedPeer.PeerID)
+		if err != nil {
+			return nil, errors.New("Could not decode peer ID from snapshot document: " + err.Error())
+		}
+		ret[id] = &ConnectedPeer{
+			NodeID:             id,
+			Direction:          network.Direction(connectedPeer.Direction),
+			SupportedProtocols: protocol.ConvertFromStrings(connectedPeer.Protocols),
+			Transport:          connectedPeer.Transport,
+			Age:                -1,
+			AgentVersion:       connectedPeer.AgentVersion,
+			Latency:            time.Duration(connectedPeer.Latency * float64(time.Millisecond)),
+			Streams:            -1,
+		}
+		if connectedPeer.Addr != "" {
+			ret[id].Addr, err = multiaddr.NewMultiaddr(connectedPeer.Addr)
+			if err != nil {
+				return nil, errors.New("Could not decode multiaddr from snapshot document: " + err.Error())
+			}
+		}
+		if connectedPeer.Age != nil {
+			ret[id].Age = time.Duration(*connectedPeer.Age) * time.Second
+		}
+		if connectedPeer.Streams != nil {
+			ret[id].Streams = *connectedPeer.Streams
+		}
+	}
+	return ret, nil
+}
+
+// annotations of the known peers of a snapshot document (if recorded), like LoadPeerAnnotations
+func DocumentPeerAnnotations(document *helpers.SnapshotDocument) (map[peer.ID]annotation.Annotation, error) {
+	ret := make(map[peer.ID]annotation.Annotation)
+	for _, knownPeer := range document.Known {
+		if knownPeer.Country == "" {
+			continue
+		}
+		id, err := peer.Decode(knownPeer.PeerID)
+		if err != nil {
+			return nil, errors.New("Could not decode peer ID from snapshot document: " + err.Error())
+		}
+		ret[id] = annotation.Annotation{Country: knownPeer.Country, ASN: knownPeer.ASN}
+	}
+	return ret, nil
+}
+
+// addresses of the known peers of a snapshot document (if recorded), like LoadKnownPeerAddrs
+func DocumentKnownPeerAddrs(document *helpers.SnapshotDocument) (map[peer.ID]helpers.AddrSummary, error) {
+	ret := make(map[peer.ID]helpers.AddrSummary)
+	for _, knownPeer := range document.Known {
+		if knownPeer.Addrs == nil {
+			continue
+		}
+		id, err := peer.Decode(knownPeer.PeerID)
+		if err != nil {
+			return nil, errors.New("Could not decode peer ID from snapshot document: " + err.Error())
+		}
+		summary := helpers.AddrSummary{
+			NumAddrs: knownPeer.Addrs.NumAddrs,
+			IPv4:     knownPeer.Addrs.IPv4,
+			IPv6:     knownPeer.Addrs.IPv6,
+			TCP:      knownPeer.Addrs.TCP,
+			QUIC:     knownPeer.Addrs.QUIC,
+			Public:   knownPeer.Addrs.Public,
+			Addrs:    make([]multiaddr.Multiaddr, 0, len(knownPeer.Addrs.Addrs)),
+		}
+		for _, addrString := range knownPeer.Addrs.Addrs {
+			addr, err := multiaddr.NewMultiaddr(addrString)
+			if err != nil {
+				continue
+			}
+			summary.Addrs = append(summary.Addrs, addr)
+		}
+		ret[id] = summary
+	}
+	return ret, nil
+}
+
+// peer sources of a snapshot document, like LoadPeerSources
+func DocumentPeerSources(document *helpers.SnapshotDocument) (map[peer.ID]string, error) {
+	ret := make(map[peer.ID]string, len(document.Sources))
+	for peerIDString, source := range document.Sources {
+		id, err := peer.Decode(peerIDString)
+		if err != nil {
+			return nil, errors.New("Could not decode peer ID from snapshot document: " + err.Error())
+		}
+		ret[id] = source
+	}
+	return ret, nil
+}
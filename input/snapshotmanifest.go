@@ -0,0 +1,28 @@
+package input
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ipfs-connect2all/helpers"
+	"os"
+)
+
+// load manifest_*.json file of a snapshot bundle
+func LoadSnapshotManifest(manifestFile string) (*helpers.SnapshotManifest, error) {
+	f, err := os.Open(manifestFile)
+	if err != nil {
+		return nil, errors.New("Could not open snapshot manifest for reading: " + err.Error())
+	}
+	defer f.Close()
+
+	var ret helpers.SnapshotManifest
+	err = json.NewDecoder(f).Decode(&ret)
+	if err != nil {
+		return nil, errors.New("JSON decode error: " + err.Error())
+	}
+	if ret.Version > helpers.SnapshotManifestVersion {
+		return nil, fmt.Errorf("Unsupported snapshot manifest version %d", ret.Version)
+	}
+	return &ret, nil
+}
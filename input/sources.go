@@ -0,0 +1,214 @@
+package input
+
+import (
+	"context"
+	"errors"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// built-in peer sources, in the order in which they are started
+func init() {
+	RegisterPeerSource("bootstrap", newBootstrapPeerSource)
+	RegisterPeerSource("dhtpeers", newVisitedPeersFilePeerSource)
+	RegisterPeerSource("dhtcrawl", newCrawlPeerSource)
+}
+
+func dhtConnsPerSec(configValues map[string]string) int {
+	ret, err := strconv.Atoi(configValues["DHTConnsPerSec"])
+	if err != nil {
+		return 5
+	}
+	return ret
+}
+
+// bootstrap peers, all at once (without filters with UnfilteredBootstrap)
+type bootstrapPeerSource struct {
+	bootstrapPeers map[peer.ID]*peer.AddrInfo
+	unfiltered     bool
+}
+
+func newBootstrapPeerSource(env PeerSourceEnv) (PeerSource, error) {
+	if len(env.BootstrapPeers) == 0 {
+		return nil, nil
+	}
+	return &bootstrapPeerSource{
+		bootstrapPeers: env.BootstrapPeers,
+		unfiltered:     env.ConfigValues["UnfilteredBootstrap"] == "1",
+	}, nil
+}
+
+func (s *bootstrapPeerSource) Name() string {
+	return "bootstrap"
+}
+
+func (s *bootstrapPeerSource) PeersPerSecond() int {
+	return 0
+}
+
+func (s *bootstrapPeerSource) Unfiltered() bool {
+	return s.unfiltered
+}
+
+func (s *bootstrapPeerSource) Run(ctx context.Context, peers chan<- peer.AddrInfo) error {
+	sendPeers(ctx, peers, s.bootstrapPeers)
+	return nil
+}
+
+// reachable peers from a visitedPeers*.json file of an ipfs-crawler run (DHTPeers option)
+type visitedPeersFilePeerSource struct {
+	filename       string
+	peersPerSecond int
+}
+
+func newVisitedPeersFilePeerSource(env PeerSourceEnv) (PeerSource, error) {
+	if env.ConfigValues["DHTPeers"] == "" {
+		return nil, nil
+	}
+	return &visitedPeersFilePeerSource{
+		filename:       env.ConfigValues["DHTPeers"],
+		peersPerSecond: dhtConnsPerSec(env.ConfigValues),
+	}, nil
+}
+
+func (s *visitedPeersFilePeerSource) Name() string {
+	return "dhtpeers"
+}
+
+func (s *visitedPeersFilePeerSource) PeersPerSecond() int {
+	return s.peersPerSecond
+}
+
+func (s *visitedPeersFilePeerSource) Run(ctx context.Context, peers chan<- peer.AddrInfo) error {
+	visitedPeers, err := LoadVisitedPeers(s.filename)
+	if err != nil {
+		return errors.New("Error loading peers from DHT scan: " + err.Error())
+	}
+	sendPeers(ctx, peers, VisitedPeersToAddrInfoMap(visitedPeers))
+	return nil
+}
+
+// reachable peers from DHT crawls with ipfs-crawler or the built-in crawler in intervals (DHTCrawlInterval option)
+type crawlPeerSource struct {
+	config         CrawlConfig
+	builtin        bool
+	builtinConfig  BuiltinCrawlConfig
+	routing        CrawlRouting
+	host           CrawlHost
+	policy         CrawlPolicy
+	interval       time.Duration
+	statusFile     string
+	status         *CrawlStatus
+	bootstrapPeers []*peer.AddrInfo
+	peersPerSecond int
+}
+
+func newCrawlPeerSource(env PeerSourceEnv) (PeerSource, error) {
+	configValues := env.ConfigValues
+	if configValues["DHTCrawlInterval"] == "" {
+		return nil, nil
+	}
+
+	builtin := configValues["DHTCrawler"] == "builtin"
+	if builtin {
+		if env.CrawlRouting == nil || env.CrawlHost == nil {
+			return nil, errors.New("built-in DHT crawler needs the DHT and host of the running node")
+		}
+	} else {
+		_, weakKeysAllowed := os.LookupEnv("LIBP2P_ALLOW_WEAK_RSA_KEYS")
+		if !weakKeysAllowed {
+			log.Println("Warning: LIBP2P_ALLOW_WEAK_RSA_KEYS not set, crawling might not find most nodes.")
+		}
+	}
+
+	interval, err := time.ParseDuration(configValues["DHTCrawlInterval"])
+	if err != nil {
+		interval = time.Hour * 1
+	}
+	crawlRetries, err := strconv.Atoi(configValues["DHTCrawlRetries"])
+	if err != nil || crawlRetries < 0 {
+		crawlRetries = 2
+	}
+	crawlRetryDelay, err := time.ParseDuration(configValues["DHTCrawlRetryDelay"])
+	if err != nil {
+		crawlRetryDelay = time.Minute * 5
+	}
+	status := env.CrawlStatus
+	if status == nil {
+		status = NewCrawlStatus()
+	}
+
+	bootstrapPeers := make([]*peer.AddrInfo, 0, len(env.BootstrapPeers))
+	for _, peerInfo := range env.BootstrapPeers {
+		bootstrapPeers = append(bootstrapPeers, peerInfo)
+	}
+
+	return &crawlPeerSource{
+		config:         NewCrawlConfig(configValues),
+		builtin:        builtin,
+		builtinConfig:  NewBuiltinCrawlConfig(configValues),
+		routing:        env.CrawlRouting,
+		host:           env.CrawlHost,
+		policy:         CrawlPolicy{Retries: crawlRetries, RetryDelay: crawlRetryDelay},
+		interval:       interval,
+		statusFile:     configValues["DHTCrawlStatus"],
+		status:         status,
+		bootstrapPeers: bootstrapPeers,
+		peersPerSecond: dhtConnsPerSec(configValues),
+	}, nil
+}
+
+func (s *crawlPeerSource) Name() string {
+	return "dhtcrawl"
+}
+
+func (s *crawlPeerSource) PeersPerSecond() int {
+	return s.peersPerSecond
+}
+
+func (s *crawlPeerSource) Run(ctx context.Context, peers chan<- peer.AddrInfo) error {
+	for {
+		crawlStart := time.Now()
+		var crawlResult *CrawlResult
+		var err error
+		if s.builtin {
+			crawlResult, err = CrawlWithRetries(func() (*CrawlResult, error) {
+				return CrawlDHTBuiltin(ctx, s.builtinConfig, s.routing, s.host)
+			}, s.policy, s.status)
+		} else {
+			crawlResult, err = CrawlDHTWithRetries(s.config, s.bootstrapPeers, s.policy, s.status)
+		}
+		s.writeStatus()
+		if err != nil {
+			log.Printf("DHT crawl failed after %d retries, waiting for next interval: %s", s.policy.Retries, err)
+		} else {
+			dhtPeers := crawlResult.ReachableAddrInfos()
+			log.Printf("DHT crawl from %s to %s finished: %d peers found, %d reachable (output: %s)",
+				crawlResult.StartDate, crawlResult.EndDate, len(crawlResult.Nodes), len(dhtPeers),
+				crawlResult.VisitedPeersFile)
+			if !sendPeers(ctx, peers, dhtPeers) {
+				return nil
+			}
+		}
+
+		// next crawl one interval after the start of this one, but not before the peers have been passed on
+		select {
+		case <-time.After(time.Until(crawlStart.Add(s.interval))):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (s *crawlPeerSource) writeStatus() {
+	if s.statusFile == "" {
+		return
+	}
+	err := s.status.WriteToFile(s.statusFile)
+	if err != nil {
+		log.Printf("Could not write DHT crawl status file: %s", err)
+	}
+}
@@ -0,0 +1,138 @@
+package input
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"ipfs-connect2all/helpers"
+	"math"
+	"sort"
+	"sync"
+)
+
+// decides which wantlists are logged: peers from WantlistOfPeers and a peer list file (reloadable), a sample of
+// the peers by hashed peer ID, and the peer filter (allowlist/denylist); the same peers are passed to the bitswap
+// wantlist cache (see CachePeers), so that the wantlists of other peers are not cached either
+type WantlistFilter struct {
+	// fixed peers (WantlistOfPeers)
+	peers map[peer.ID]bool
+	// peer list file, empty if not used
+	peersFile  string
+	sampleRate float64
+	peerFilter *PeerFilter
+	mutex      *sync.RWMutex
+	// fixed peers and peers from the file
+	activePeers map[peer.ID]bool
+}
+
+// create wantlist filter and load the peer list file (empty filename: not used); sampleRate is the share of peers
+// whose wantlists are logged (1: all), peerFilter may be nil
+func NewWantlistFilter(peers map[peer.ID]bool, peersFile string, sampleRate float64,
+	peerFilter *PeerFilter) (*WantlistFilter, error) {
+	if sampleRate <= 0 || sampleRate > 1 {
+		return nil, errors.New("Invalid wantlist sample rate (should be greater than 0 and at most 1)")
+	}
+	ret := &WantlistFilter{
+		peers:      peers,
+		peersFile:  peersFile,
+		sampleRate: sampleRate,
+		peerFilter: peerFilter,
+		mutex:      &sync.RWMutex{},
+	}
+	err := ret.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// reload the peer list file, keeping the old list if an error occurs
+func (f *WantlistFilter) Reload() error {
+	activePeers := make(map[peer.ID]bool, len(f.peers))
+	for peerID := range f.peers {
+		activePeers[peerID] = true
+	}
+	if f.peersFile != "" {
+		filePeers, err := LoadPeerList(f.peersFile)
+		if err != nil {
+			return errors.New("Could not load wantlist peer list: " + err.Error())
+		}
+		for peerID := range filePeers {
+			activePeers[peerID] = true
+		}
+	}
+	f.mutex.Lock()
+	f.activePeers = activePeers
+	f.mutex.Unlock()
+	return nil
+}
+
+// returns true if the peer list can change at runtime (see Reload)
+func (f *WantlistFilter) IsReloadable() bool {
+	return f.peersFile != ""
+}
+
+// returns true if the wantlists of the peer are logged
+func (f *WantlistFilter) IsAllowed(peerID peer.ID) bool {
+	f.mutex.RLock()
+	activePeers := f.activePeers
+	f.mutex.RUnlock()
+	if len(activePeers) > 0 && !activePeers[peerID] {
+		return false
+	}
+	if f.sampleRate < 1 && !inSample(peerID, f.sampleRate) {
+		return false
+	}
+	if f.peerFilter != nil && !f.peerFilter.IsAllowed(peerID) {
+		return false
+	}
+	return true
+}
+
+// the same peers are in the sample in every run with the same rate
+func inSample(peerID peer.ID, sampleRate float64) bool {
+	hash := sha256.Sum256([]byte(peerID))
+	return float64(binary.BigEndian.Uint64(hash[:8])) < sampleRate*math.MaxUint64
+}
+
+// the allowed peers of the fixed peers and the peer list file, or, if both are empty, of the connected peers
+// (the sample and the peer filter are no fixed set); nil if all peers are allowed
+func (f *WantlistFilter) CachePeers(connectedPeers []peer.ID) map[peer.ID]bool {
+	f.mutex.RLock()
+	activePeers := f.activePeers
+	f.mutex.RUnlock()
+	if len(activePeers) == 0 && f.sampleRate >= 1 && (f.peerFilter == nil || !f.peerFilter.IsActive()) {
+		return nil
+	}
+	ret := make(map[peer.ID]bool)
+	if len(activePeers) > 0 {
+		for peerID := range activePeers {
+			if f.IsAllowed(peerID) {
+				ret[peerID] = true
+			}
+		}
+		return ret
+	}
+	for _, peerID := range connectedPeers {
+		if f.IsAllowed(peerID) {
+			ret[peerID] = true
+		}
+	}
+	return ret
+}
+
+func (f *WantlistFilter) Metadata() helpers.WantlistFilterMetadata {
+	f.mutex.RLock()
+	peers := make([]string, 0, len(f.activePeers))
+	for peerID := range f.activePeers {
+		peers = append(peers, peerID.Pretty())
+	}
+	f.mutex.RUnlock()
+	sort.Strings(peers)
+	return helpers.WantlistFilterMetadata{
+		Peers:      peers,
+		SampleRate: f.sampleRate,
+		PeerLists:  f.peerFilter != nil && f.peerFilter.IsActive(),
+	}
+}
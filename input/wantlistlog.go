@@ -0,0 +1,179 @@
+package input
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ipfs-connect2all/helpers"
+	"sort"
+	"strings"
+	"time"
+)
+
+// entry of the hand-written wantlistLog format (version 0), times in the format of time.Time.String()
+type legacyWantlistEntry struct {
+	FirstWantHave  string
+	LastWantHave   string
+	NumWantHave    int
+	FirstWantBlock string
+	LastWantBlock  string
+	NumWantBlock   int
+}
+
+func parseLegacyWantlistTime(value string) (*time.Time, error) {
+	// strip monotonic clock reading
+	if mPos := strings.Index(value, " m="); mPos >= 0 {
+		value = value[:mPos]
+	}
+	t, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", value)
+	if err != nil {
+		return nil, err
+	}
+	if t.IsZero() {
+		return nil, nil
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+func legacyWantlistLogPeer(peerID string, entries map[string]legacyWantlistEntry) (helpers.WantlistLogPeer, error) {
+	ret := helpers.WantlistLogPeer{
+		PeerID:  peerID,
+		Entries: make([]helpers.WantlistLogEntry, 0, len(entries)),
+	}
+	for contentID, entry := range entries {
+		newEntry := helpers.WantlistLogEntry{
+			Cid:          contentID,
+			NumWantHave:  entry.NumWantHave,
+			NumWantBlock: entry.NumWantBlock,
+		}
+		var err error
+		for _, field := range []struct {
+			value  string
+			target **time.Time
+		}{
+			{entry.FirstWantHave, &newEntry.FirstWantHave},
+			{entry.LastWantHave, &newEntry.LastWantHave},
+			{entry.FirstWantBlock, &newEntry.FirstWantBlock},
+			{entry.LastWantBlock, &newEntry.LastWantBlock},
+		} {
+			*field.target, err = parseLegacyWantlistTime(field.value)
+			if err != nil {
+				return ret, errors.New("Could not parse time in wantlistLog file: " + err.Error())
+			}
+		}
+		ret.Entries = append(ret.Entries, newEntry)
+	}
+	sort.Slice(ret.Entries, func(i, j int) bool {
+		return ret.Entries[i].Cid < ret.Entries[j].Cid
+	})
+	return ret, nil
+}
+
+// read wantlistLog file (gzip- or zstd-compressed or not) peer by peer without loading it completely; returns the
+// header (without peers); files in the hand-written format (version 0) have no timestamp
+func ReadWantlistLog(wantlistLogFile string, handlePeer func(helpers.WantlistLogPeer) error) (*helpers.WantlistLog,
+	error) {
+	r, err := helpers.OpenDecompressed(wantlistLogFile)
+	if err != nil {
+		return nil, errors.New("Could not open wantlistLog file for reading: " + err.Error())
+	}
+	defer r.Close()
+
+	invalid := func(err error) error {
+		return errors.New("Invalid wantlistLog file: " + err.Error())
+	}
+	decoder := json.NewDecoder(r)
+	expectDelim := func(delim json.Delim) error {
+		token, err := decoder.Token()
+		if err != nil {
+			return invalid(err)
+		}
+		if token != delim {
+			return invalid(fmt.Errorf("expected %s", delim))
+		}
+		return nil
+	}
+
+	ret := &helpers.WantlistLog{}
+	if err := expectDelim('{'); err != nil {
+		return nil, err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, invalid(err)
+		}
+		key, _ := token.(string)
+		switch {
+		case key == "Version":
+			if err := decoder.Decode(&ret.Version); err != nil {
+				return nil, invalid(err)
+			}
+			if ret.Version > helpers.WantlistLogVersion {
+				return nil, fmt.Errorf("Unsupported wantlistLog version %d", ret.Version)
+			}
+		case key == "Timestamp":
+			if err := decoder.Decode(&ret.Timestamp); err != nil {
+				return nil, invalid(err)
+			}
+		case key == "Filter":
+			if err := decoder.Decode(&ret.Filter); err != nil {
+				return nil, invalid(err)
+			}
+		case key == "Peers":
+			if err := expectDelim('['); err != nil {
+				return nil, err
+			}
+			for decoder.More() {
+				var logPeer helpers.WantlistLogPeer
+				if err := decoder.Decode(&logPeer); err != nil {
+					return nil, invalid(err)
+				}
+				if err := handlePeer(logPeer); err != nil {
+					return nil, err
+				}
+			}
+			if err := expectDelim(']'); err != nil {
+				return nil, err
+			}
+		case ret.Version > 0:
+			// unknown field of a newer version
+			var ignored json.RawMessage
+			if err := decoder.Decode(&ignored); err != nil {
+				return nil, invalid(err)
+			}
+		default:
+			// version 0: peer ID as key, map of CIDs to entries as value
+			var entries map[string]legacyWantlistEntry
+			if err := decoder.Decode(&entries); err != nil {
+				return nil, invalid(err)
+			}
+			logPeer, err := legacyWantlistLogPeer(key, entries)
+			if err != nil {
+				return nil, err
+			}
+			if err := handlePeer(logPeer); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := expectDelim('}'); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// load complete wantlistLog file, see ReadWantlistLog
+func LoadWantlistLog(wantlistLogFile string) (*helpers.WantlistLog, error) {
+	var peers []helpers.WantlistLogPeer
+	ret, err := ReadWantlistLog(wantlistLogFile, func(logPeer helpers.WantlistLogPeer) error {
+		peers = append(peers, logPeer)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ret.Peers = peers
+	return ret, nil
+}
@@ -0,0 +1,179 @@
+package input
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterPeerSource("dhtwatch", newWatchDirPeerSource)
+}
+
+// reachable peers from visitedPeers_*.json files appearing in a directory, e.g., written by an ipfs-crawler
+// running separately (DHTPeersWatchDir option)
+type watchDirPeerSource struct {
+	dir            string
+	interval       time.Duration
+	stateFile      string
+	peersPerSecond int
+
+	processedMutex *sync.Mutex
+	processed      map[string]bool
+}
+
+type watchedFileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func newWatchDirPeerSource(env PeerSourceEnv) (PeerSource, error) {
+	configValues := env.ConfigValues
+	if configValues["DHTPeersWatchDir"] == "" {
+		return nil, nil
+	}
+	interval, err := time.ParseDuration(configValues["DHTPeersWatchInterval"])
+	if err != nil || interval <= 0 {
+		interval = time.Second * 30
+	}
+	ret := &watchDirPeerSource{
+		dir:            configValues["DHTPeersWatchDir"],
+		interval:       interval,
+		stateFile:      configValues["DHTPeersWatchState"],
+		peersPerSecond: dhtConnsPerSec(configValues),
+		processedMutex: &sync.Mutex{},
+		processed:      make(map[string]bool),
+	}
+	if ret.stateFile != "" {
+		processed, err := loadProcessedFiles(ret.stateFile)
+		if err != nil {
+			return nil, err
+		}
+		ret.processed = processed
+	}
+	return ret, nil
+}
+
+func (s *watchDirPeerSource) Name() string {
+	return "dhtwatch"
+}
+
+func (s *watchDirPeerSource) PeersPerSecond() int {
+	return s.peersPerSecond
+}
+
+func (s *watchDirPeerSource) Run(ctx context.Context, peers chan<- peer.AddrInfo) error {
+	// files are only read once their size and modification time did not change for one interval
+	pending := make(map[string]watchedFileState)
+	for {
+		candidates, err := s.findNewFiles()
+		if err != nil {
+			log.Printf("Could not read watched directory %s: %s", s.dir, err)
+		}
+		stillPending := make(map[string]watchedFileState)
+		for _, filename := range candidates {
+			stat, err := os.Stat(filepath.Join(s.dir, filename))
+			if err != nil {
+				continue
+			}
+			state := watchedFileState{size: stat.Size(), modTime: stat.ModTime()}
+			if lastState, ok := pending[filename]; !ok || lastState != state || state.size == 0 {
+				stillPending[filename] = state
+				continue
+			}
+
+			visitedPeers, err := LoadVisitedPeers(filepath.Join(s.dir, filename))
+			if err != nil {
+				// might still be incomplete, try again later
+				log.Printf("Could not load watched visitedPeers file %s, retrying: %s", filename, err)
+				stillPending[filename] = state
+				continue
+			}
+			dhtPeers := VisitedPeersToAddrInfoMap(visitedPeers)
+			log.Printf("Queueing %d reachable peers from %s", len(dhtPeers), filename)
+			// only mark as processed once all peers have been delivered, so that an interrupted file is read
+			// again after a restart
+			if !sendPeers(ctx, peers, dhtPeers) {
+				return nil
+			}
+			s.markProcessed(filename)
+		}
+		pending = stillPending
+
+		select {
+		case <-time.After(s.interval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// visitedPeers files in the directory which have not been processed yet, sorted by name
+func (s *watchDirPeerSource) findNewFiles() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	s.processedMutex.Lock()
+	defer s.processedMutex.Unlock()
+	ret := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "visitedPeers_") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		if !s.processed[name] {
+			ret = append(ret, name)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+func (s *watchDirPeerSource) markProcessed(filename string) {
+	s.processedMutex.Lock()
+	defer s.processedMutex.Unlock()
+	s.processed[filename] = true
+	if s.stateFile == "" {
+		return
+	}
+	f, err := os.OpenFile(s.stateFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Could not record processed file %s: %s", filename, err)
+		return
+	}
+	defer f.Close()
+	_, err = f.WriteString(filename + "\n")
+	if err != nil {
+		log.Printf("Could not record processed file %s: %s", filename, err)
+	}
+}
+
+// load names of already processed files, one per line (a missing file means nothing has been processed)
+func loadProcessedFiles(stateFile string) (map[string]bool, error) {
+	ret := make(map[string]bool)
+	f, err := os.Open(stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ret, nil
+		}
+		return nil, errors.New("Could not open file with processed visitedPeers files: " + err.Error())
+	}
+	defer f.Close()
+	scn := bufio.NewScanner(f)
+	for scn.Scan() {
+		if st := strings.TrimSpace(scn.Text()); st != "" {
+			ret[st] = true
+		}
+	}
+	return ret, nil
+}